@@ -0,0 +1,171 @@
+package image_utils
+
+// This file contains functions for measuring how different two colors look,
+// for use when exact equality, as tested by ColorsEqual, is too strict.
+
+import (
+	"image/color"
+	"math"
+)
+
+// A function returning a non-negative measure of the difference between two
+// colors, where 0 means that they're identical.
+type ColorDifference func(a, b color.Color) float64
+
+// A ColorDifference returning the straight-line distance between the colors'
+// R, G, B and A components, in units of 8-bit channel values. The components
+// are the alpha-premultiplied sRGB values returned by the RGBA method.
+func EuclideanRGBDifference(a, b color.Color) float64 {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	dr := float64(r1) - float64(r2)
+	dg := float64(g1) - float64(g2)
+	db := float64(b1) - float64(b2)
+	da := float64(a1) - float64(a2)
+	return math.Sqrt(dr*dr+dg*dg+db*db+da*da) / 257.0
+}
+
+// Converts c to Lab relative to D65, so that colors relative to different
+// white points can be compared.
+func toD65Lab(c color.Color) LabColor {
+	lab := RGBToLab(c)
+	if lab.White.orDefault() == D65WhitePoint {
+		return lab
+	}
+	return XYZToLab(lab.XYZ(), D65WhitePoint)
+}
+
+// Returns the CIE76 color difference: the straight-line distance between two
+// Lab colors. A difference of roughly 2.3 is just noticeable. Both colors must
+// be relative to the same white point.
+func DeltaE76(a, b LabColor) float64 {
+	dl := a.L - b.L
+	da := a.A - b.A
+	db := a.B - b.B
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// Returns the CIE94 color difference, using the constants for graphic arts.
+// Note that CIE94 isn't symmetric: a is treated as the reference color. Both
+// colors must be relative to the same white point.
+func DeltaE94(a, b LabColor) float64 {
+	dl := a.L - b.L
+	c1 := math.Hypot(a.A, a.B)
+	c2 := math.Hypot(b.A, b.B)
+	dc := c1 - c2
+	da := a.A - b.A
+	db := a.B - b.B
+	// The hue difference is what remains of the Lab distance after removing
+	// the lightness and chroma differences.
+	dhSquared := da*da + db*db - dc*dc
+	if dhSquared < 0 {
+		dhSquared = 0
+	}
+	sc := 1.0 + 0.045*c1
+	sh := 1.0 + 0.015*c1
+	dc /= sc
+	return math.Sqrt(dl*dl + dc*dc + dhSquared/(sh*sh))
+}
+
+// Converts an angle in degrees to radians.
+func degreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180.0
+}
+
+// Returns the CIEDE2000 color difference, which corrects CIE94's remaining
+// perceptual nonuniformities, mostly in blues and near-neutral colors. Both
+// colors must be relative to the same white point. Based on "The CIEDE2000
+// Color-Difference Formula: Implementation Notes, Supplementary Test Data, and
+// Mathematical Observations" by Sharma, Wu and Dalal.
+func DeltaE2000(a, b LabColor) float64 {
+	c1 := math.Hypot(a.A, a.B)
+	c2 := math.Hypot(b.A, b.B)
+	meanC := (c1 + c2) / 2.0
+	meanC7 := math.Pow(meanC, 7)
+	g := 0.5 * (1.0 - math.Sqrt(meanC7/(meanC7+math.Pow(25.0, 7))))
+	a1 := a.A * (1.0 + g)
+	a2 := b.A * (1.0 + g)
+	c1 = math.Hypot(a1, a.B)
+	c2 = math.Hypot(a2, b.B)
+	h1 := 0.0
+	if c1 != 0 {
+		h1 = math.Mod(math.Atan2(a.B, a1)*180.0/math.Pi+360.0, 360.0)
+	}
+	h2 := 0.0
+	if c2 != 0 {
+		h2 = math.Mod(math.Atan2(b.B, a2)*180.0/math.Pi+360.0, 360.0)
+	}
+
+	dl := b.L - a.L
+	dc := c2 - c1
+	dh := 0.0
+	if (c1 * c2) != 0 {
+		dh = h2 - h1
+		if dh > 180.0 {
+			dh -= 360.0
+		} else if dh < -180.0 {
+			dh += 360.0
+		}
+	}
+	dhBig := 2.0 * math.Sqrt(c1*c2) * math.Sin(degreesToRadians(dh/2.0))
+
+	meanL := (a.L + b.L) / 2.0
+	meanC = (c1 + c2) / 2.0
+	meanH := h1 + h2
+	if (c1 * c2) != 0 {
+		if math.Abs(h1-h2) <= 180.0 {
+			meanH /= 2.0
+		} else if (h1 + h2) < 360.0 {
+			meanH = (h1 + h2 + 360.0) / 2.0
+		} else {
+			meanH = (h1 + h2 - 360.0) / 2.0
+		}
+	}
+	t := 1.0 - 0.17*math.Cos(degreesToRadians(meanH-30.0)) +
+		0.24*math.Cos(degreesToRadians(2.0*meanH)) +
+		0.32*math.Cos(degreesToRadians(3.0*meanH+6.0)) -
+		0.20*math.Cos(degreesToRadians(4.0*meanH-63.0))
+	dTheta := 30.0 * math.Exp(-math.Pow((meanH-275.0)/25.0, 2))
+	meanC7 = math.Pow(meanC, 7)
+	rc := 2.0 * math.Sqrt(meanC7/(meanC7+math.Pow(25.0, 7)))
+	lOffset := (meanL - 50.0) * (meanL - 50.0)
+	sl := 1.0 + 0.015*lOffset/math.Sqrt(20.0+lOffset)
+	sc := 1.0 + 0.045*meanC
+	sh := 1.0 + 0.015*meanC*t
+	rt := -math.Sin(degreesToRadians(2.0*dTheta)) * rc
+
+	dl /= sl
+	dc /= sc
+	dhBig /= sh
+	return math.Sqrt(dl*dl + dc*dc + dhBig*dhBig + rt*dc*dhBig)
+}
+
+// A ColorDifference returning DeltaE76 of the colors' Lab values. Ignores
+// alpha.
+func CIE76Difference(a, b color.Color) float64 {
+	return DeltaE76(toD65Lab(a), toD65Lab(b))
+}
+
+// A ColorDifference returning DeltaE94 of the colors' Lab values, using a as
+// the reference. Ignores alpha.
+func CIE94Difference(a, b color.Color) float64 {
+	return DeltaE94(toD65Lab(a), toD65Lab(b))
+}
+
+// A ColorDifference returning DeltaE2000 of the colors' Lab values. Ignores
+// alpha.
+func CIEDE2000Difference(a, b color.Color) float64 {
+	return DeltaE2000(toD65Lab(a), toD65Lab(b))
+}
+
+// Returns true if the difference between a and b, according to the given
+// metric, is no more than the tolerance. Uses CIEDE2000Difference if metric is
+// nil. A tolerance of 0 with EuclideanRGBDifference is equivalent to
+// ColorsEqual.
+func ColorsSimilar(a, b color.Color, tolerance float64,
+	metric ColorDifference) bool {
+	if metric == nil {
+		metric = CIEDE2000Difference
+	}
+	return metric(a, b) <= tolerance
+}
@@ -0,0 +1,380 @@
+package image_utils
+
+// This file contains the CIE XYZ, CIELAB and CIELCh color spaces, along with
+// the sRGB transfer function and an image type that stores Lab pixels.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Converts a gamma-encoded sRGB component in [0, 1] to linear light.
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Converts a linear-light component in [0, 1] to gamma-encoded sRGB. This is
+// the inverse of SRGBToLinear.
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1.0/2.4) - 0.055
+}
+
+// A 3x3 matrix, used for converting between color spaces.
+type matrix3 [3][3]float64
+
+// Returns the product of the matrix and the column vector (a, b, c).
+func (m *matrix3) apply(a, b, c float64) (float64, float64, float64) {
+	return m[0][0]*a + m[0][1]*b + m[0][2]*c,
+		m[1][0]*a + m[1][1]*b + m[1][2]*c,
+		m[2][0]*a + m[2][1]*b + m[2][2]*c
+}
+
+// Converts linear sRGB to XYZ, relative to the D65 white point.
+var linearSRGBToXYZ = matrix3{
+	{0.4124564, 0.3575761, 0.1804375},
+	{0.2126729, 0.7151522, 0.0721750},
+	{0.0193339, 0.1191920, 0.9503041},
+}
+
+// The inverse of linearSRGBToXYZ.
+var xyzToLinearSRGB = matrix3{
+	{3.2404542, -1.5371385, -0.4985314},
+	{-0.9692660, 1.8760108, 0.0415560},
+	{0.0556434, -0.2040259, 1.0572252},
+}
+
+// Converts XYZ to the cone responses used by the Bradford chromatic
+// adaptation transform.
+var bradfordMatrix = matrix3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// The inverse of bradfordMatrix.
+var inverseBradfordMatrix = matrix3{
+	{0.9869929, -0.1470543, 0.1599627},
+	{0.4323053, 0.5183603, 0.0492912},
+	{-0.0085287, 0.0400428, 0.9684867},
+}
+
+// The XYZ coordinates of a reference white, with Y normalized to 1.
+type WhitePoint struct {
+	X, Y, Z float64
+}
+
+// The CIE standard illuminant D65, used by sRGB.
+var D65WhitePoint = WhitePoint{X: 0.95047, Y: 1.0, Z: 1.08883}
+
+// The CIE standard illuminant D50, used by ICC profiles and print work.
+var D50WhitePoint = WhitePoint{X: 0.96422, Y: 1.0, Z: 0.82521}
+
+// Returns D65WhitePoint if w is the zero value, or w otherwise.
+func (w WhitePoint) orDefault() WhitePoint {
+	if w == (WhitePoint{}) {
+		return D65WhitePoint
+	}
+	return w
+}
+
+// Implements the color interface. Holds CIE 1931 XYZ coordinates relative to
+// the D65 white point used by sRGB, where Y is 1 for white. Colors outside of
+// the sRGB gamut are clamped when converted to RGBA.
+type XYZColor struct {
+	X, Y, Z float64
+}
+
+func (c XYZColor) String() string {
+	return fmt.Sprintf("XYZ(%f, %f, %f)", c.X, c.Y, c.Z)
+}
+
+func (c XYZColor) RGBA() (r, g, b, a uint32) {
+	r1, g1, b1 := xyzToLinearSRGB.apply(c.X, c.Y, c.Z)
+	r = uint32(scaleTo16Bit(LinearToSRGB(clamp(r1))))
+	g = uint32(scaleTo16Bit(LinearToSRGB(clamp(g1))))
+	b = uint32(scaleTo16Bit(LinearToSRGB(clamp(b1))))
+	a = 0xffff
+	return
+}
+
+// Converts an arbitrary color to XYZ. Ignores alpha. Returns the original
+// color if it's already an XYZColor.
+func RGBToXYZ(c color.Color) XYZColor {
+	xyz, ok := c.(XYZColor)
+	if ok {
+		return xyz
+	}
+	r, g, b := colorToRGBFloats(c)
+	x, y, z := linearSRGBToXYZ.apply(SRGBToLinear(r), SRGBToLinear(g),
+		SRGBToLinear(b))
+	return XYZColor{X: x, Y: y, Z: z}
+}
+
+// A color.Model that converts colors to XYZColor values.
+var XYZModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToXYZ(c)
+})
+
+// Uses the Bradford transform to convert c, which is relative to the from
+// white point, to the corresponding color relative to the to white point.
+func AdaptXYZ(c XYZColor, from, to WhitePoint) XYZColor {
+	from = from.orDefault()
+	to = to.orDefault()
+	if from == to {
+		return c
+	}
+	fromL, fromM, fromS := bradfordMatrix.apply(from.X, from.Y, from.Z)
+	toL, toM, toS := bradfordMatrix.apply(to.X, to.Y, to.Z)
+	l, m, s := bradfordMatrix.apply(c.X, c.Y, c.Z)
+	x, y, z := inverseBradfordMatrix.apply(l*toL/fromL, m*toM/fromM,
+		s*toS/fromS)
+	return XYZColor{X: x, Y: y, Z: z}
+}
+
+// Constants from the CIELAB definition, in the exact rational forms
+// recommended by the CIE.
+const (
+	labEpsilon = 216.0 / 24389.0
+	labKappa   = 24389.0 / 27.0
+)
+
+// The nonlinear function applied to each normalized XYZ coordinate by CIELAB.
+func labF(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (labKappa*t + 16.0) / 116.0
+}
+
+// The inverse of labF.
+func labFInverse(f float64) float64 {
+	cubed := f * f * f
+	if cubed > labEpsilon {
+		return cubed
+	}
+	return (116.0*f - 16.0) / labKappa
+}
+
+// Implements the color interface. Holds CIELAB coordinates, where L is from 0
+// (black) to 100 (white), and A and B are typically within [-128, 127]. The
+// coordinates are relative to the given white point, or to D65 if White is the
+// zero value. Colors outside of the sRGB gamut are clamped when converted to
+// RGBA.
+type LabColor struct {
+	L, A, B float64
+	White   WhitePoint
+}
+
+func (c LabColor) String() string {
+	return fmt.Sprintf("Lab(%f, %f, %f)", c.L, c.A, c.B)
+}
+
+// Converts the color to XYZ, relative to D65.
+func (c LabColor) XYZ() XYZColor {
+	white := c.White.orDefault()
+	fy := (c.L + 16.0) / 116.0
+	fx := fy + c.A/500.0
+	fz := fy - c.B/200.0
+	y := c.L / labKappa
+	if c.L > labKappa*labEpsilon {
+		y = fy * fy * fy
+	}
+	xyz := XYZColor{
+		X: labFInverse(fx) * white.X,
+		Y: y * white.Y,
+		Z: labFInverse(fz) * white.Z,
+	}
+	return AdaptXYZ(xyz, white, D65WhitePoint)
+}
+
+func (c LabColor) RGBA() (r, g, b, a uint32) {
+	return c.XYZ().RGBA()
+}
+
+// Converts an XYZ color, relative to D65, to Lab relative to the given white
+// point. Pass D65WhitePoint or the zero WhitePoint to skip adaptation.
+func XYZToLab(c XYZColor, white WhitePoint) LabColor {
+	white = white.orDefault()
+	c = AdaptXYZ(c, D65WhitePoint, white)
+	fx := labF(c.X / white.X)
+	fy := labF(c.Y / white.Y)
+	fz := labF(c.Z / white.Z)
+	return LabColor{
+		L:     116.0*fy - 16.0,
+		A:     500.0 * (fx - fy),
+		B:     200.0 * (fy - fz),
+		White: white,
+	}
+}
+
+// Converts an arbitrary color to Lab, relative to D65. Ignores alpha. Returns
+// the original color if it's already a LabColor.
+func RGBToLab(c color.Color) LabColor {
+	lab, ok := c.(LabColor)
+	if ok {
+		return lab
+	}
+	return XYZToLab(RGBToXYZ(c), D65WhitePoint)
+}
+
+// Returns a color.Model that converts colors to LabColor values relative to
+// the given white point.
+func NewLabModel(white WhitePoint) color.Model {
+	white = white.orDefault()
+	return color.ModelFunc(func(c color.Color) color.Color {
+		lab, ok := c.(LabColor)
+		if ok && (lab.White.orDefault() == white) {
+			return lab
+		}
+		return XYZToLab(RGBToXYZ(c), white)
+	})
+}
+
+// A color.Model that converts colors to LabColor values relative to D65.
+var LabModel color.Model = NewLabModel(D65WhitePoint)
+
+// Implements the color interface. Holds CIELCh coordinates, the polar form of
+// CIELAB. L is the same as in LabColor, C is the chroma, and H is the hue
+// angle in degrees, in [0, 360). The zero White means D65, as in LabColor.
+type LChColor struct {
+	L, C, H float64
+	White   WhitePoint
+}
+
+func (c LChColor) String() string {
+	return fmt.Sprintf("LCh(%f, %f, %f)", c.L, c.C, c.H)
+}
+
+// Converts the color to the equivalent LabColor.
+func (c LChColor) Lab() LabColor {
+	radians := c.H * math.Pi / 180.0
+	return LabColor{
+		L:     c.L,
+		A:     c.C * math.Cos(radians),
+		B:     c.C * math.Sin(radians),
+		White: c.White,
+	}
+}
+
+func (c LChColor) RGBA() (r, g, b, a uint32) {
+	return c.Lab().RGBA()
+}
+
+// Converts the color to the equivalent LChColor.
+func (c LabColor) LCh() LChColor {
+	h := math.Atan2(c.B, c.A) * 180.0 / math.Pi
+	if h < 0 {
+		h += 360.0
+	}
+	return LChColor{
+		L:     c.L,
+		C:     math.Hypot(c.A, c.B),
+		H:     h,
+		White: c.White,
+	}
+}
+
+// Converts an arbitrary color to LCh, relative to D65. Ignores alpha. Returns
+// the original color if it's already an LChColor.
+func RGBToLCh(c color.Color) LChColor {
+	lch, ok := c.(LChColor)
+	if ok {
+		return lch
+	}
+	return RGBToLab(c).LCh()
+}
+
+// A color.Model that converts colors to LChColor values relative to D65.
+var LChModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToLCh(c)
+})
+
+// Implements the image interface, storing the L, A and B components of each
+// pixel in separate planes. Alpha isn't stored.
+type LabImage struct {
+	W, H    int
+	L, A, B []float32
+	// The white point the pixels are relative to. The zero value means D65.
+	White WhitePoint
+}
+
+func (l *LabImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, l.W, l.H)
+}
+
+func (l *LabImage) ColorModel() color.Model {
+	return NewLabModel(l.White)
+}
+
+// Returns the LabColor at (x, y), or black if the coordinate is outside of the
+// image boundaries.
+func (l *LabImage) LabPixel(x, y int) LabColor {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return LabColor{White: l.White}
+	}
+	i := y*l.W + x
+	return LabColor{
+		L:     float64(l.L[i]),
+		A:     float64(l.A[i]),
+		B:     float64(l.B[i]),
+		White: l.White,
+	}
+}
+
+func (l *LabImage) At(x, y int) color.Color {
+	return l.LabPixel(x, y)
+}
+
+// Sets the pixel at (x, y) to the given color, converted to Lab relative to
+// the image's white point. Does nothing if (x, y) is outside of the image.
+func (l *LabImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return
+	}
+	lab := l.ColorModel().Convert(c).(LabColor)
+	i := y*l.W + x
+	l.L[i] = float32(lab.L)
+	l.A[i] = float32(lab.A)
+	l.B[i] = float32(lab.B)
+}
+
+// Creates a new LabImage, relative to the given white point, where every pixel
+// is black.
+func NewLabImage(w, h int, white WhitePoint) (*LabImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	return &LabImage{
+		W:     w,
+		H:     h,
+		L:     make([]float32, w*h),
+		A:     make([]float32, w*h),
+		B:     make([]float32, w*h),
+		White: white.orDefault(),
+	}, nil
+}
+
+// Returns a new LabImage containing a copy of pic, converted to Lab relative
+// to the given white point. The new image's top-left corner will be at (0, 0).
+func NewLabImageFromImage(pic image.Image,
+	white WhitePoint) (*LabImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewLabImage(bounds.Dx(), bounds.Dy(), white)
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
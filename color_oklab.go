@@ -0,0 +1,191 @@
+package image_utils
+
+// This file contains Björn Ottosson's OKLab color space and its polar form,
+// OKLCh, along with helpers for blending colors through them. See
+// https://bottosson.github.io/posts/oklab/
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Converts linear sRGB to the approximate cone responses used by OKLab.
+var linearSRGBToLMS = matrix3{
+	{0.4122214708, 0.5363325363, 0.0514459929},
+	{0.2119034982, 0.6806995451, 0.1073969566},
+	{0.0883024619, 0.2817188376, 0.6299787005},
+}
+
+// Converts the cube roots of the cone responses to OKLab.
+var lmsToOKLab = matrix3{
+	{0.2104542553, 0.7936177850, -0.0040720468},
+	{1.9779984951, -2.4285922050, 0.4505937099},
+	{0.0259040371, 0.7827717662, -0.8086757660},
+}
+
+// The inverse of lmsToOKLab.
+var okLabToLMS = matrix3{
+	{1.0, 0.3963377774, 0.2158037573},
+	{1.0, -0.1055613458, -0.0638541728},
+	{1.0, -0.0894841775, -1.2914855480},
+}
+
+// The inverse of linearSRGBToLMS.
+var lmsToLinearSRGB = matrix3{
+	{4.0767416621, -3.3077115913, 0.2309699292},
+	{-1.2684380046, 2.6097574011, -0.3413193965},
+	{-0.0041960863, -0.7034186147, 1.7076147010},
+}
+
+// Implements the color interface. Holds OKLab coordinates, where L is from 0
+// (black) to 1 (white), and A and B are roughly within [-0.4, 0.4]. Colors
+// outside of the sRGB gamut are clamped when converted to RGBA.
+type OKLabColor struct {
+	L, A, B float64
+}
+
+func (c OKLabColor) String() string {
+	return fmt.Sprintf("OKLab(%f, %f, %f)", c.L, c.A, c.B)
+}
+
+func (c OKLabColor) RGBA() (r, g, b, a uint32) {
+	l, m, s := okLabToLMS.apply(c.L, c.A, c.B)
+	r1, g1, b1 := lmsToLinearSRGB.apply(l*l*l, m*m*m, s*s*s)
+	r = uint32(scaleTo16Bit(LinearToSRGB(clamp(r1))))
+	g = uint32(scaleTo16Bit(LinearToSRGB(clamp(g1))))
+	b = uint32(scaleTo16Bit(LinearToSRGB(clamp(b1))))
+	a = 0xffff
+	return
+}
+
+// Converts an arbitrary color to OKLab. Ignores alpha. Returns the original
+// color if it's already an OKLabColor.
+func RGBToOKLab(c color.Color) OKLabColor {
+	okLab, ok := c.(OKLabColor)
+	if ok {
+		return okLab
+	}
+	r, g, b := colorToRGBFloats(c)
+	l, m, s := linearSRGBToLMS.apply(SRGBToLinear(r), SRGBToLinear(g),
+		SRGBToLinear(b))
+	okL, okA, okB := lmsToOKLab.apply(math.Cbrt(l), math.Cbrt(m),
+		math.Cbrt(s))
+	return OKLabColor{L: okL, A: okA, B: okB}
+}
+
+// A color.Model that converts colors to OKLabColor values.
+var OKLabModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToOKLab(c)
+})
+
+// Implements the color interface. Holds OKLCh coordinates, the polar form of
+// OKLab. L is the same as in OKLabColor, C is the chroma, and H is the hue
+// angle in degrees, in [0, 360).
+type OKLChColor struct {
+	L, C, H float64
+}
+
+func (c OKLChColor) String() string {
+	return fmt.Sprintf("OKLCh(%f, %f, %f)", c.L, c.C, c.H)
+}
+
+// Converts the color to the equivalent OKLabColor.
+func (c OKLChColor) OKLab() OKLabColor {
+	radians := c.H * math.Pi / 180.0
+	return OKLabColor{
+		L: c.L,
+		A: c.C * math.Cos(radians),
+		B: c.C * math.Sin(radians),
+	}
+}
+
+func (c OKLChColor) RGBA() (r, g, b, a uint32) {
+	return c.OKLab().RGBA()
+}
+
+// Converts the color to the equivalent OKLChColor.
+func (c OKLabColor) OKLCh() OKLChColor {
+	h := math.Atan2(c.B, c.A) * 180.0 / math.Pi
+	if h < 0 {
+		h += 360.0
+	}
+	return OKLChColor{
+		L: c.L,
+		C: math.Hypot(c.A, c.B),
+		H: h,
+	}
+}
+
+// Converts an arbitrary color to OKLCh. Ignores alpha. Returns the original
+// color if it's already an OKLChColor.
+func RGBToOKLCh(c color.Color) OKLChColor {
+	okLCh, ok := c.(OKLChColor)
+	if ok {
+		return okLCh
+	}
+	return RGBToOKLab(c).OKLCh()
+}
+
+// A color.Model that converts colors to OKLChColor values.
+var OKLChModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToOKLCh(c)
+})
+
+// Returns the color t of the way from a to b, interpolated in OKLab. A t of 0
+// returns a and a t of 1 returns b. Ignores alpha.
+func LerpOKLab(a, b color.Color, t float64) OKLabColor {
+	labA := RGBToOKLab(a)
+	labB := RGBToOKLab(b)
+	return OKLabColor{
+		L: labA.L + (labB.L-labA.L)*t,
+		A: labA.A + (labB.A-labA.A)*t,
+		B: labA.B + (labB.B-labA.B)*t,
+	}
+}
+
+// The chroma below which an OKLCh color is considered gray, so its hue is
+// meaningless.
+const okLChGrayChroma = 1e-4
+
+// Like LerpOKLab, but interpolates in OKLCh, going the shorter way around the
+// hue circle. This keeps intermediate colors saturated, where LerpOKLab passes
+// near gray when blending complementary colors. If one of the colors is gray,
+// the other's hue is used throughout.
+func LerpOKLCh(a, b color.Color, t float64) OKLChColor {
+	lchA := RGBToOKLCh(a)
+	lchB := RGBToOKLCh(b)
+	if lchA.C < okLChGrayChroma {
+		lchA.H = lchB.H
+	}
+	if lchB.C < okLChGrayChroma {
+		lchB.H = lchA.H
+	}
+	hueDelta := hueDifference(lchA.H/360.0, lchB.H/360.0) * 360.0
+	return OKLChColor{
+		L: lchA.L + (lchB.L-lchA.L)*t,
+		C: lchA.C + (lchB.C-lchA.C)*t,
+		H: wrapHue((lchA.H+hueDelta*t)/360.0) * 360.0,
+	}
+}
+
+// Returns the given number of evenly-spaced colors blending from one color to
+// another through OKLab, including both endpoints. If polar is true, the
+// colors are blended through OKLCh instead. Requires at least two steps.
+func OKLabGradient(from, to color.Color, steps int,
+	polar bool) ([]color.Color, error) {
+	if steps < 2 {
+		return nil, fmt.Errorf("A gradient needs at least 2 steps, got %d",
+			steps)
+	}
+	toReturn := make([]color.Color, steps)
+	for i := range toReturn {
+		t := float64(i) / float64(steps-1)
+		if polar {
+			toReturn[i] = LerpOKLCh(from, to, t)
+		} else {
+			toReturn[i] = LerpOKLab(from, to, t)
+		}
+	}
+	return toReturn, nil
+}
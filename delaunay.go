@@ -0,0 +1,299 @@
+package image_utils
+
+// This file contains an implementation of Delaunay triangulation, the dual of
+// the Voronoi diagram, along with functions for drawing triangulations.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// A triangle with integer vertices.
+type Triangle struct {
+	A, B, C image.Point
+}
+
+// Returns the smallest rectangle containing all three of the triangle's
+// vertices.
+func (t Triangle) Bounds() image.Rectangle {
+	toReturn := image.Rect(t.A.X, t.A.Y, t.A.X+1, t.A.Y+1)
+	toReturn = toReturn.Union(image.Rect(t.B.X, t.B.Y, t.B.X+1, t.B.Y+1))
+	return toReturn.Union(image.Rect(t.C.X, t.C.Y, t.C.X+1, t.C.Y+1))
+}
+
+// Returns twice the signed area of the triangle (a, b, p). Positive if p is to
+// the left of the line from a to b, in a coordinate system where Y increases
+// downwards.
+func edgeFunction(a, b, p image.Point) int {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+// Returns true if p is inside the triangle or on its edges.
+func (t Triangle) Contains(p image.Point) bool {
+	e0 := edgeFunction(t.A, t.B, p)
+	e1 := edgeFunction(t.B, t.C, p)
+	e2 := edgeFunction(t.C, t.A, p)
+	return ((e0 >= 0) && (e1 >= 0) && (e2 >= 0)) ||
+		((e0 <= 0) && (e1 <= 0) && (e2 <= 0))
+}
+
+// Returns the location of each seed in the image, in order from left to right
+// and top to bottom. Useful for computing the Delaunay triangulation of a
+// Voronoi diagram's seeds.
+func (v *VoronoiImage) SeedLocations() []image.Point {
+	toReturn := make([]image.Point, 0, 64)
+	for i := range v.Pixels {
+		if v.Pixels[i].IsSeed {
+			toReturn = append(toReturn, image.Pt(i%v.W, i/v.W))
+		}
+	}
+	return toReturn
+}
+
+// Used in place of a vertex index to refer to the "ghost" vertex at infinity.
+// Each edge of the convex hull forms a ghost triangle with it, so points
+// outside of the hull can be inserted like any other point.
+const ghostVertex = -1
+
+// A triangle used internally by the Bowyer-Watson algorithm. Refers to
+// vertices by their indices, and caches its circumcircle. The vertices are
+// ordered so that, in a real triangle, the third vertex is to the left of the
+// edge from the first to the second.
+type delaunayTriangle struct {
+	v                [3]int
+	centerX, centerY float64
+	radiusSquared    float64
+	hasCircumcircle  bool
+	// True if the third vertex is the ghost vertex, in which case the
+	// triangle represents the region to the left of the hull edge from the
+	// first vertex to the second.
+	isGhost bool
+}
+
+// Computes the triangle's circumcircle using the given vertex locations.
+func newDelaunayTriangle(a, b, c int,
+	points []image.Point) delaunayTriangle {
+	// Rotate the vertices so the ghost vertex, if any, is last.
+	if a == ghostVertex {
+		a, b, c = b, c, a
+	} else if b == ghostVertex {
+		a, b, c = c, a, b
+	}
+	toReturn := delaunayTriangle{
+		v: [3]int{a, b, c},
+	}
+	if c == ghostVertex {
+		toReturn.isGhost = true
+		return toReturn
+	}
+	ax, ay := float64(points[a].X), float64(points[a].Y)
+	bx, by := float64(points[b].X), float64(points[b].Y)
+	cx, cy := float64(points[c].X), float64(points[c].Y)
+	d := 2.0 * (ax*(by-cy) + bx*(cy-ay) + cx*(ay-by))
+	if d == 0 {
+		// The vertices are collinear, so treat the circumcircle as
+		// containing every point, which ensures the triangle is replaced.
+		return toReturn
+	}
+	a2 := ax*ax + ay*ay
+	b2 := bx*bx + by*by
+	c2 := cx*cx + cy*cy
+	toReturn.centerX = (a2*(by-cy) + b2*(cy-ay) + c2*(ay-by)) / d
+	toReturn.centerY = (a2*(cx-bx) + b2*(ax-cx) + c2*(bx-ax)) / d
+	dx := ax - toReturn.centerX
+	dy := ay - toReturn.centerY
+	toReturn.radiusSquared = dx*dx + dy*dy
+	toReturn.hasCircumcircle = true
+	return toReturn
+}
+
+// Returns true if p is strictly inside the triangle's circumcircle. The
+// circumcircle of a ghost triangle is the limit of a circle through the hull
+// edge with its third point moving to infinity: the open half-plane outside
+// of the edge, along with the open edge itself.
+func (t *delaunayTriangle) circumcircleContains(p image.Point,
+	points []image.Point) bool {
+	if t.isGhost {
+		a := points[t.v[0]]
+		b := points[t.v[1]]
+		side := edgeFunction(a, b, p)
+		if side != 0 {
+			return side > 0
+		}
+		// p is on the line through the edge, so check if it's between the
+		// edge's endpoints.
+		return ((p.X-a.X)*(p.X-b.X) + (p.Y-a.Y)*(p.Y-b.Y)) < 0
+	}
+	if !t.hasCircumcircle {
+		return true
+	}
+	dx := float64(p.X) - t.centerX
+	dy := float64(p.Y) - t.centerY
+	return (dx*dx + dy*dy) < t.radiusSquared
+}
+
+// Returns the Delaunay triangulation of the given points, computed using the
+// Bowyer-Watson algorithm. Duplicate points are ignored. Returns an error if
+// fewer than three distinct points are given. Returns no triangles if all of
+// the points are collinear.
+func DelaunayTriangulation(points []image.Point) ([]Triangle, error) {
+	// Remove duplicate points.
+	seen := make(map[image.Point]bool)
+	unique := make([]image.Point, 0, len(points))
+	for _, p := range points {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		unique = append(unique, p)
+	}
+	n := len(unique)
+	if n < 3 {
+		return nil, fmt.Errorf("At least 3 distinct points are required, "+
+			"got %d", n)
+	}
+
+	// Start with the first triangle formed by non-collinear points, along
+	// with the ghost triangles outside of each of its edges.
+	third := 2
+	for edgeFunction(unique[0], unique[1], unique[third]) == 0 {
+		third++
+		if third >= n {
+			return []Triangle{}, nil
+		}
+	}
+	a, b, c := 0, 1, third
+	if edgeFunction(unique[a], unique[b], unique[c]) < 0 {
+		a, b = b, a
+	}
+	triangles := []delaunayTriangle{
+		newDelaunayTriangle(a, b, c, unique),
+		newDelaunayTriangle(b, a, ghostVertex, unique),
+		newDelaunayTriangle(c, b, ghostVertex, unique),
+		newDelaunayTriangle(a, c, ghostVertex, unique),
+	}
+
+	for i := 0; i < n; i++ {
+		if (i == 0) || (i == 1) || (i == third) {
+			continue
+		}
+		p := unique[i]
+		// Find the triangles whose circumcircles contain the new point, and
+		// the edges of the polygonal hole left by removing them. Edges shared
+		// by two removed triangles aren't on the hole's boundary.
+		edgeCounts := make(map[[2]int]int)
+		edges := make([][2]int, 0, 16)
+		kept := triangles[:0]
+		for _, t := range triangles {
+			if !t.circumcircleContains(p, unique) {
+				kept = append(kept, t)
+				continue
+			}
+			for j := 0; j < 3; j++ {
+				a := t.v[j]
+				b := t.v[(j+1)%3]
+				key := [2]int{a, b}
+				if a > b {
+					key = [2]int{b, a}
+				}
+				if edgeCounts[key] == 0 {
+					edges = append(edges, [2]int{a, b})
+				}
+				edgeCounts[key]++
+			}
+		}
+		triangles = kept
+		for _, edge := range edges {
+			key := edge
+			if key[0] > key[1] {
+				key = [2]int{key[1], key[0]}
+			}
+			if edgeCounts[key] != 1 {
+				continue
+			}
+			triangles = append(triangles, newDelaunayTriangle(edge[0],
+				edge[1], i, unique))
+		}
+	}
+
+	// Discard the ghost triangles.
+	toReturn := make([]Triangle, 0, len(triangles))
+	for _, t := range triangles {
+		if t.isGhost {
+			continue
+		}
+		result := Triangle{
+			A: unique[t.v[0]],
+			B: unique[t.v[1]],
+			C: unique[t.v[2]],
+		}
+		if edgeFunction(result.A, result.B, result.C) == 0 {
+			continue
+		}
+		toReturn = append(toReturn, result)
+	}
+	return toReturn, nil
+}
+
+// Draws the edges of each triangle to dst using DrawLine. Edges shared by two
+// triangles are drawn twice.
+func DrawTriangleEdges(triangles []Triangle, c color.Color,
+	dst DrawableImage) error {
+	for i, t := range triangles {
+		for _, edge := range [][2]image.Point{{t.A, t.B}, {t.B, t.C},
+			{t.C, t.A}} {
+			e := DrawLine(edge[0], edge[1], c, dst)
+			if e != nil {
+				return fmt.Errorf("Error drawing triangle %d: %w", i, e)
+			}
+			// DrawLine doesn't include its final point.
+			dst.Set(edge[1].X, edge[1].Y, c)
+		}
+	}
+	return nil
+}
+
+// Fills each triangle in dst with the average color of the pixels it covers in
+// src, producing a "low-poly" version of src. The triangles' coordinates are
+// used in both src and dst.
+func FillTrianglesWithAverageColor(triangles []Triangle, src image.Image,
+	dst DrawableImage) error {
+	srcBounds := src.Bounds()
+	for _, t := range triangles {
+		bounds := t.Bounds()
+		var sum [4]uint64
+		count := uint64(0)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				p := image.Pt(x, y)
+				if !p.In(srcBounds) || !t.Contains(p) {
+					continue
+				}
+				r, g, b, a := src.At(x, y).RGBA()
+				sum[0] += uint64(r)
+				sum[1] += uint64(g)
+				sum[2] += uint64(b)
+				sum[3] += uint64(a)
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		average := color.RGBA64{
+			R: uint16(sum[0] / count),
+			G: uint16(sum[1] / count),
+			B: uint16(sum[2] / count),
+			A: uint16(sum[3] / count),
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if t.Contains(image.Pt(x, y)) {
+					dst.Set(x, y, average)
+				}
+			}
+		}
+	}
+	return nil
+}
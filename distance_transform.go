@@ -0,0 +1,215 @@
+package image_utils
+
+// This file contains functions for computing distance fields, containing the
+// distance from each pixel to the nearest "seed" pixel.
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Selects the algorithm used to compute a distance transform.
+type DistanceTransformMethod int
+
+const (
+	// Computes exact Euclidean distances using the algorithm from
+	// Felzenszwalb and Huttenlocher's "Distance Transforms of Sampled
+	// Functions."
+	ExactDistance DistanceTransformMethod = iota
+	// Computes approximate distances using the Jump-Flooding algorithm, the
+	// same as VoronoiFill.
+	JumpFloodDistance
+)
+
+func (m DistanceTransformMethod) String() string {
+	switch m {
+	case ExactDistance:
+		return "exact"
+	case JumpFloodDistance:
+		return "jump flood"
+	}
+	return fmt.Sprintf("unknown distance transform method %d", int(m))
+}
+
+// Computes the one-dimensional squared distance transform of f, writing the
+// result to d. The v and z slices are scratch space, and must contain at least
+// len(f) and len(f) + 1 entries, respectively.
+func squaredDistance1D(f, d []float64, v []int, z []float64) {
+	n := len(f)
+	k := 0
+	v[0] = 0
+	z[0] = math.Inf(-1)
+	z[1] = math.Inf(1)
+	for q := 1; q < n; q++ {
+		fq := f[q] + float64(q*q)
+		s := (fq - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*(q-v[k]))
+		for s <= z[k] {
+			k--
+			s = (fq - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*(q-v[k]))
+		}
+		k++
+		v[k] = q
+		z[k] = s
+		z[k+1] = math.Inf(1)
+	}
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		dq := float64(q - v[k])
+		d[q] = dq*dq + f[v[k]]
+	}
+}
+
+// Returns the exact Euclidean distance from each pixel to the closest seed.
+// Returns an error if there are no seeds.
+func exactDistanceTransform(w, h int,
+	isSeed func(x, y int) bool) (*FloatGrayscaleImage, error) {
+	squared := make([]float64, w*h)
+	anySeeds := false
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if isSeed(x, y) {
+				anySeeds = true
+				continue
+			}
+			squared[y*w+x] = arbitraryLargeFloat
+		}
+	}
+	if !anySeeds {
+		return nil, fmt.Errorf("No seed pixels were provided")
+	}
+	n := w
+	if h > n {
+		n = h
+	}
+	f := make([]float64, n)
+	d := make([]float64, n)
+	v := make([]int, n)
+	z := make([]float64, n+1)
+
+	// Transform along each column first, followed by each row.
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = squared[y*w+x]
+		}
+		squaredDistance1D(f[:h], d[:h], v, z)
+		for y := 0; y < h; y++ {
+			squared[y*w+x] = d[y]
+		}
+	}
+	toReturn, e := NewFloatGrayscaleImage(w, h)
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < h; y++ {
+		row := squared[y*w : (y+1)*w]
+		squaredDistance1D(row, d[:w], v, z)
+		for x := 0; x < w; x++ {
+			toReturn.Pixels[y*w+x] = float32(math.Sqrt(d[x]))
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns the approximate distance from each pixel to the closest seed,
+// computed using the Jump-Flooding algorithm.
+func jumpFloodDistanceTransform(w, h int,
+	isSeed func(x, y int) bool) (*FloatGrayscaleImage, error) {
+	pixels := make([]VoronoiPixel, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !isSeed(x, y) {
+				continue
+			}
+			p := &(pixels[y*w+x])
+			p.IsSeed = true
+			p.IsDefined = true
+			p.Color = color.White
+			p.SeedLocation.X = x
+			p.SeedLocation.Y = y
+		}
+	}
+	v := &VoronoiImage{
+		W:      w,
+		H:      h,
+		Pixels: pixels,
+	}
+	e := v.JumpFloodFill()
+	if e != nil {
+		return nil, fmt.Errorf("Error running Jump-Flooding algorithm: %w", e)
+	}
+	return v.DistanceField()
+}
+
+// Returns an image where each pixel contains the distance, in pixels, from
+// that pixel to the nearest pixel for which isSeed returns true. The image
+// will be w x h pixels. Note that the FloatGrayscaleImage's Pixels contain the
+// raw distances, which will usually be greater than 1, so they must be
+// rescaled before being displayed. Returns an error if no pixels are seeds.
+func DistanceTransform(w, h int, isSeed func(x, y int) bool,
+	method DistanceTransformMethod) (*FloatGrayscaleImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	switch method {
+	case ExactDistance:
+		return exactDistanceTransform(w, h, isSeed)
+	case JumpFloodDistance:
+		return jumpFloodDistanceTransform(w, h, isSeed)
+	}
+	return nil, fmt.Errorf("Unsupported distance transform method: %s",
+		method)
+}
+
+// Returns a signed distance field for the w x h shape described by isInside.
+// Pixels outside of the shape contain their positive distance to the closest
+// pixel inside of it, and pixels inside of the shape contain the negated
+// distance to the closest pixel outside of it. Returns an error if the shape
+// is empty or covers the entire image.
+func SignedDistanceTransform(w, h int, isInside func(x, y int) bool,
+	method DistanceTransformMethod) (*FloatGrayscaleImage, error) {
+	outside, e := DistanceTransform(w, h, isInside, method)
+	if e != nil {
+		return nil, fmt.Errorf("Error computing distances outside the "+
+			"shape: %w", e)
+	}
+	inside, e := DistanceTransform(w, h, func(x, y int) bool {
+		return !isInside(x, y)
+	}, method)
+	if e != nil {
+		return nil, fmt.Errorf("Error computing distances inside the "+
+			"shape: %w", e)
+	}
+	for i := range outside.Pixels {
+		if inside.Pixels[i] > 0 {
+			outside.Pixels[i] = -inside.Pixels[i]
+		}
+	}
+	return outside, nil
+}
+
+// Returns an image containing the distance from each pixel to its current
+// seed location. Intended to be called after JumpFloodFill. Pixels that
+// haven't been assigned a seed are set to +Inf.
+func (v *VoronoiImage) DistanceField() (*FloatGrayscaleImage, error) {
+	toReturn, e := NewFloatGrayscaleImage(v.W, v.H)
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < v.H; y++ {
+		for x := 0; x < v.W; x++ {
+			p := v.At(x, y)
+			if !p.IsDefined {
+				toReturn.Pixels[y*v.W+x] = float32(math.Inf(1))
+				continue
+			}
+			dx := float64(x - p.SeedLocation.X)
+			dy := float64(y - p.SeedLocation.Y)
+			toReturn.Pixels[y*v.W+x] = float32(math.Sqrt(dx*dx + dy*dy))
+		}
+	}
+	return toReturn, nil
+}
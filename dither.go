@@ -0,0 +1,464 @@
+package image_utils
+
+// This file contains error-diffusion and ordered dithering, for reducing
+// images to a limited palette without banding.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// A palette containing only black and white, for 1-bit output.
+var BlackAndWhitePalette = color.Palette{color.Black, color.White}
+
+// Selects the dithering algorithm used by DitherToPalette.
+type DitherMethod int
+
+const (
+	// Diffuses each pixel's error to 4 neighbors. The most common method.
+	FloydSteinbergDither DitherMethod = iota
+	// Diffuses only 3/4 of each pixel's error, to 6 neighbors. Preserves
+	// detail and contrast at the cost of losing some shadow and highlight
+	// detail. Popular for 1-bit output.
+	AtkinsonDither
+	// Jarvis, Judice and Ninke's method, which diffuses error to 12
+	// neighbors. Smoother than Floyd-Steinberg, but slower.
+	JarvisJudiceNinkeDither
+	// Similar to JarvisJudiceNinkeDither, with slightly sharper results.
+	StuckiDither
+	// Frankie Sierra's three-row method, which diffuses error to 10
+	// neighbors.
+	SierraDither
+	// Ordered dithering using a 2x2 Bayer threshold matrix.
+	Bayer2x2Dither
+	// Ordered dithering using a 4x4 Bayer threshold matrix.
+	Bayer4x4Dither
+	// Ordered dithering using an 8x8 Bayer threshold matrix.
+	Bayer8x8Dither
+	// Ordered dithering using a blue-noise threshold map, which avoids the
+	// visible cross-hatched patterns of Bayer matrices.
+	BlueNoiseDither
+)
+
+func (m DitherMethod) String() string {
+	switch m {
+	case FloydSteinbergDither:
+		return "Floyd-Steinberg"
+	case AtkinsonDither:
+		return "Atkinson"
+	case JarvisJudiceNinkeDither:
+		return "Jarvis-Judice-Ninke"
+	case StuckiDither:
+		return "Stucki"
+	case SierraDither:
+		return "Sierra"
+	case Bayer2x2Dither:
+		return "Bayer 2x2"
+	case Bayer4x4Dither:
+		return "Bayer 4x4"
+	case Bayer8x8Dither:
+		return "Bayer 8x8"
+	case BlueNoiseDither:
+		return "blue noise"
+	}
+	return fmt.Sprintf("unknown dither method %d", int(m))
+}
+
+// Holds the parameters for DitherToPalette.
+type DitherOptions struct {
+	// The dithering algorithm to use.
+	Method DitherMethod
+	// If true, error-diffusion methods process every other row from right to
+	// left, which reduces directional artifacts. Ignored by ordered
+	// dithering.
+	Serpentine bool
+}
+
+// One entry in an error-diffusion kernel: the fraction of a pixel's error
+// passed to the pixel at the given offset, where positive dx is in the
+// scanning direction.
+type diffusionWeight struct {
+	dx, dy int
+	weight float32
+}
+
+// Returns the error-diffusion kernel for the given method, or nil if the
+// method doesn't use error diffusion.
+func diffusionKernel(m DitherMethod) []diffusionWeight {
+	switch m {
+	case FloydSteinbergDither:
+		return []diffusionWeight{
+			{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16},
+			{1, 1, 1.0 / 16},
+		}
+	case AtkinsonDither:
+		return []diffusionWeight{
+			{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8},
+			{0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+		}
+	case JarvisJudiceNinkeDither:
+		return []diffusionWeight{
+			{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+			{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48},
+			{1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+			{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48},
+			{1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+		}
+	case StuckiDither:
+		return []diffusionWeight{
+			{1, 0, 8.0 / 42}, {2, 0, 4.0 / 42},
+			{-2, 1, 2.0 / 42}, {-1, 1, 4.0 / 42}, {0, 1, 8.0 / 42},
+			{1, 1, 4.0 / 42}, {2, 1, 2.0 / 42},
+			{-2, 2, 1.0 / 42}, {-1, 2, 2.0 / 42}, {0, 2, 4.0 / 42},
+			{1, 2, 2.0 / 42}, {2, 2, 1.0 / 42},
+		}
+	case SierraDither:
+		return []diffusionWeight{
+			{1, 0, 5.0 / 32}, {2, 0, 3.0 / 32},
+			{-2, 1, 2.0 / 32}, {-1, 1, 4.0 / 32}, {0, 1, 5.0 / 32},
+			{1, 1, 4.0 / 32}, {2, 1, 2.0 / 32},
+			{-1, 2, 2.0 / 32}, {0, 2, 3.0 / 32}, {1, 2, 2.0 / 32},
+		}
+	}
+	return nil
+}
+
+// Returns an n x n Bayer matrix, where n is a power of 2, containing each
+// value from 0 to n*n - 1 once.
+func bayerMatrix(n int) [][]int {
+	if n == 1 {
+		return [][]int{{0}}
+	}
+	smaller := bayerMatrix(n / 2)
+	half := n / 2
+	toReturn := make([][]int, n)
+	for y := range toReturn {
+		toReturn[y] = make([]int, n)
+		for x := range toReturn[y] {
+			v := 4 * smaller[y%half][x%half]
+			switch {
+			case (x < half) && (y < half):
+			case (x >= half) && (y < half):
+				v += 2
+			case (x < half) && (y >= half):
+				v += 3
+			default:
+				v++
+			}
+			toReturn[y][x] = v
+		}
+	}
+	return toReturn
+}
+
+// The size of the blue-noise threshold map, which is tiled over the image.
+const blueNoiseSize = 64
+
+var blueNoiseOnce sync.Once
+
+// Holds the rank of each pixel in the blue-noise threshold map, from 0 to
+// blueNoiseSize^2 - 1. Generated by blueNoiseRanks.
+var blueNoiseMap []int
+
+// Generates a blue-noise threshold map using Ulichney's void-and-cluster
+// method, the first time it's called, and returns it. The map is the same
+// every time.
+func blueNoiseRanks() []int {
+	blueNoiseOnce.Do(func() {
+		blueNoiseMap = generateBlueNoise(blueNoiseSize, 1.5, 1)
+	})
+	return blueNoiseMap
+}
+
+// Implements the void-and-cluster method for a size x size map that wraps
+// around at its edges. Clusters and voids are found using a Gaussian filter
+// with the given standard deviation.
+func generateBlueNoise(size int, sigma float64, rngSeed int64) []int {
+	count := size * size
+	// Precompute the filter for every wrapped offset.
+	filter := make([]float64, count)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x := float64(dx)
+			if dx > size/2 {
+				x = float64(dx - size)
+			}
+			y := float64(dy)
+			if dy > size/2 {
+				y = float64(dy - size)
+			}
+			filter[dy*size+dx] = math.Exp(-(x*x + y*y) / (2 * sigma * sigma))
+		}
+	}
+	pattern := make([]bool, count)
+	energy := make([]float64, count)
+	// Adds or removes a point, updating the filtered energy at every pixel.
+	toggle := func(i int) {
+		pattern[i] = !pattern[i]
+		sign := 1.0
+		if !pattern[i] {
+			sign = -1.0
+		}
+		px := i % size
+		py := i / size
+		for y := 0; y < size; y++ {
+			dy := ((y - py) + size) % size
+			for x := 0; x < size; x++ {
+				dx := ((x - px) + size) % size
+				energy[y*size+x] += sign * filter[dy*size+dx]
+			}
+		}
+	}
+	// Returns the point with the highest energy: the tightest cluster.
+	tightestCluster := func() int {
+		best := -1
+		for i := range pattern {
+			if pattern[i] && ((best < 0) || (energy[i] > energy[best])) {
+				best = i
+			}
+		}
+		return best
+	}
+	// Returns the empty pixel with the lowest energy: the largest void.
+	largestVoid := func() int {
+		best := -1
+		for i := range pattern {
+			if !pattern[i] && ((best < 0) || (energy[i] < energy[best])) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Start with a random pattern covering a tenth of the map, then move
+	// points from clusters into voids until it's evenly distributed.
+	rng := rand.New(rand.NewSource(rngSeed))
+	initialCount := count / 10
+	for _, i := range rng.Perm(count)[:initialCount] {
+		toggle(i)
+	}
+	for {
+		cluster := tightestCluster()
+		toggle(cluster)
+		void := largestVoid()
+		if void == cluster {
+			toggle(cluster)
+			break
+		}
+		toggle(void)
+	}
+	initialPattern := make([]bool, count)
+	copy(initialPattern, pattern)
+	initialEnergy := make([]float64, count)
+	copy(initialEnergy, energy)
+
+	ranks := make([]int, count)
+	// Rank the initial points by removing the tightest clusters first.
+	for rank := initialCount - 1; rank >= 0; rank-- {
+		cluster := tightestCluster()
+		toggle(cluster)
+		ranks[cluster] = rank
+	}
+	// Rank the remaining pixels by filling the largest voids first.
+	copy(pattern, initialPattern)
+	copy(energy, initialEnergy)
+	for rank := initialCount; rank < count; rank++ {
+		void := largestVoid()
+		toggle(void)
+		ranks[void] = rank
+	}
+	return ranks
+}
+
+// Returns the threshold at (x, y) for the given ordered dithering method, in
+// the range (-0.5, 0.5).
+func orderedThreshold(m DitherMethod, bayer [][]int, x, y int) float32 {
+	if m == BlueNoiseDither {
+		ranks := blueNoiseRanks()
+		i := (y%blueNoiseSize)*blueNoiseSize + (x % blueNoiseSize)
+		return (float32(ranks[i])+0.5)/float32(len(ranks)) - 0.5
+	}
+	n := len(bayer)
+	return (float32(bayer[y%n][x%n])+0.5)/float32(n*n) - 0.5
+}
+
+// Returns the index of the palette entry closest to the given premultiplied
+// components, using the same measure as color.Palette's Index method.
+func closestPaletteEntry(entries [][4]float32, c [4]float32) int {
+	best := 0
+	bestDistance := float32(math.Inf(1))
+	for i, entry := range entries {
+		distance := float32(0)
+		for j := range entry {
+			d := entry[j] - c[j]
+			distance += d * d
+		}
+		if distance < bestDistance {
+			best = i
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// Returns the indices of the two palette entries closest to the given
+// premultiplied components, with the closest first. Both indices are the same
+// if the palette contains only one entry.
+func twoClosestPaletteEntries(entries [][4]float32, c [4]float32) (int,
+	int) {
+	best := 0
+	second := 0
+	bestDistance := float32(math.Inf(1))
+	secondDistance := float32(math.Inf(1))
+	for i, entry := range entries {
+		distance := float32(0)
+		for j := range entry {
+			d := entry[j] - c[j]
+			distance += d * d
+		}
+		if distance < bestDistance {
+			second = best
+			secondDistance = bestDistance
+			best = i
+			bestDistance = distance
+		} else if distance < secondDistance {
+			second = i
+			secondDistance = distance
+		}
+	}
+	if len(entries) == 1 {
+		second = best
+	}
+	return best, second
+}
+
+// Projects c onto the line from palette entry a to entry b, returning how far
+// along the line it falls, clamped to [0, 1]. Returns 0 if a and b are the
+// same.
+func paletteInterpolation(a, b, c [4]float32) float32 {
+	dot := float32(0)
+	lengthSquared := float32(0)
+	for i := range a {
+		d := b[i] - a[i]
+		dot += (c[i] - a[i]) * d
+		lengthSquared += d * d
+	}
+	if lengthSquared == 0 {
+		return 0
+	}
+	return clamp32(dot / lengthSquared)
+}
+
+// Returns the premultiplied RGBA components, in [0, 1], of the given color.
+func premultipliedComponents(c color.Color) [4]float32 {
+	r, g, b, a := c.RGBA()
+	return [4]float32{float32(r) / 0xffff, float32(g) / 0xffff,
+		float32(b) / 0xffff, float32(a) / 0xffff}
+}
+
+// Returns a copy of pic, with the same bounds, reduced to the given palette
+// using dithering. The palette must contain between 1 and 256 colors, and can
+// be chosen using QuantizePalette, or be BlackAndWhitePalette for 1-bit
+// output. Only the color channels are dithered; each pixel's alpha is used
+// as-is when choosing its palette entry. Uses Floyd-Steinberg dithering if
+// opts is nil.
+func DitherToPalette(pic image.Image, p color.Palette,
+	opts *DitherOptions) (*image.Paletted, error) {
+	if (len(p) == 0) || (len(p) > 256) {
+		return nil, fmt.Errorf("The palette must contain 1 to 256 colors, "+
+			"got %d", len(p))
+	}
+	if opts == nil {
+		opts = &DitherOptions{}
+	}
+	method := opts.Method
+	if (method < FloydSteinbergDither) || (method > BlueNoiseDither) {
+		return nil, fmt.Errorf("Invalid dither method: %s", method)
+	}
+	entries := make([][4]float32, len(p))
+	for i, c := range p {
+		entries[i] = premultipliedComponents(c)
+	}
+	bounds := pic.Bounds().Canon()
+	w := bounds.Dx()
+	h := bounds.Dy()
+	toReturn := image.NewPaletted(pic.Bounds(), p)
+	kernel := diffusionKernel(method)
+
+	if kernel == nil {
+		// Ordered dithering chooses between the two palette entries closest
+		// to each pixel, based on how far the pixel is from the closest
+		// entry towards the other one, so the threshold's amplitude always
+		// matches the local spacing between palette colors.
+		var bayer [][]int
+		switch method {
+		case Bayer2x2Dither:
+			bayer = bayerMatrix(2)
+		case Bayer4x4Dither:
+			bayer = bayerMatrix(4)
+		case Bayer8x8Dither:
+			bayer = bayerMatrix(8)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c := premultipliedComponents(pic.At(bounds.Min.X+x,
+					bounds.Min.Y+y))
+				closest, next := twoClosestPaletteEntries(entries, c)
+				index := closest
+				t := paletteInterpolation(entries[closest], entries[next], c)
+				if t > (orderedThreshold(method, bayer, x, y) + 0.5) {
+					index = next
+				}
+				toReturn.SetColorIndex(bounds.Min.X+x, bounds.Min.Y+y,
+					uint8(index))
+			}
+		}
+		return toReturn, nil
+	}
+
+	// Error diffusion. The accumulated error for each pixel's color channels
+	// is kept in a separate buffer.
+	errors := make([][3]float32, w*h)
+	for y := 0; y < h; y++ {
+		direction := 1
+		startX := 0
+		if opts.Serpentine && ((y % 2) == 1) {
+			direction = -1
+			startX = w - 1
+		}
+		for step := 0; step < w; step++ {
+			x := startX + step*direction
+			c := premultipliedComponents(pic.At(bounds.Min.X+x,
+				bounds.Min.Y+y))
+			// Premultiplied color channels can't exceed alpha.
+			for i := 0; i < 3; i++ {
+				c[i] += errors[y*w+x][i]
+				if c[i] < 0 {
+					c[i] = 0
+				}
+				if c[i] > c[3] {
+					c[i] = c[3]
+				}
+			}
+			index := closestPaletteEntry(entries, c)
+			toReturn.SetColorIndex(bounds.Min.X+x, bounds.Min.Y+y,
+				uint8(index))
+			for _, k := range kernel {
+				neighborX := x + k.dx*direction
+				neighborY := y + k.dy
+				if (neighborX < 0) || (neighborX >= w) || (neighborY >= h) {
+					continue
+				}
+				for i := 0; i < 3; i++ {
+					errors[neighborY*w+neighborX][i] += (c[i] -
+						entries[index][i]) * k.weight
+				}
+			}
+		}
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,74 @@
+package image_utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Returns a palette of the given number of evenly spaced gray levels, from
+// black to white.
+func grayLevelPalette(levels int) color.Palette {
+	toReturn := make(color.Palette, levels)
+	for i := range toReturn {
+		toReturn[i] = color.Gray16{uint16(i * 0xffff / (levels - 1))}
+	}
+	return toReturn
+}
+
+func TestOrderedDitherFlatInput(t *testing.T) {
+	methods := []DitherMethod{
+		Bayer2x2Dither,
+		Bayer4x4Dither,
+		Bayer8x8Dither,
+		BlueNoiseDither,
+	}
+	tests := []struct {
+		levels int
+		gray   float64
+		// The indices of the two palette entries bracketing the gray level.
+		low, high uint8
+	}{
+		{16, 0.5, 7, 8},
+		{16, 0.3, 4, 5},
+		{16, 0.9, 13, 14},
+		{3, 0.25, 0, 1},
+		{3, 0.6, 1, 2},
+		{2, 0.5, 0, 1},
+	}
+	for _, test := range tests {
+		p := grayLevelPalette(test.levels)
+		pic := image.NewGray16(image.Rect(0, 0, 64, 64))
+		gray := color.Gray16{uint16(test.gray * 0xffff)}
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				pic.SetGray16(x, y, gray)
+			}
+		}
+		for _, method := range methods {
+			result, e := DitherToPalette(pic, p, &DitherOptions{
+				Method: method,
+			})
+			if e != nil {
+				t.Fatalf("Failed dithering with %s: %s", method, e)
+			}
+			counts := make(map[uint8]int)
+			for _, index := range result.Pix {
+				counts[index]++
+			}
+			for index, count := range counts {
+				if (index != test.low) && (index != test.high) {
+					t.Errorf("%s dithering of %f gray to %d levels used "+
+						"index %d for %d pixels, expected only %d and %d",
+						method, test.gray, test.levels, index, count,
+						test.low, test.high)
+				}
+			}
+			if (counts[test.low] == 0) || (counts[test.high] == 0) {
+				t.Errorf("%s dithering of %f gray to %d levels didn't use "+
+					"both %d and %d: %v", method, test.gray, test.levels,
+					test.low, test.high, counts)
+			}
+		}
+	}
+}
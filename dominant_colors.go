@@ -0,0 +1,150 @@
+package image_utils
+
+// This file contains functions for finding the most prominent colors in an
+// image, and for displaying them as swatches.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// One of the colors returned by DominantColors.
+type DominantColor struct {
+	// The color, which is the average of the pixels it represents.
+	Color color.RGBA
+	// The fraction, from 0 to 1, of the considered pixels that this color
+	// represents.
+	Share float64
+}
+
+// Holds the parameters for DominantColors.
+type DominantColorOptions struct {
+	// The maximum number of colors to return. Defaults to 5 if 0.
+	Count int
+	// The algorithm used to group similar colors.
+	Method QuantizationMethod
+	// Pixels with an alpha below this are ignored. Fully transparent pixels
+	// are always ignored.
+	MinAlpha uint8
+	// If positive, pixels with a CIELAB lightness, from 0 to 100, above this
+	// are ignored. For example, 95 ignores white and near-white pixels.
+	MaxLightness float64
+	// If positive, pixels with a CIELAB lightness below this are ignored.
+	// For example, 5 ignores black and near-black pixels.
+	MinLightness float64
+}
+
+// Returns the most prominent colors in pic, sorted from the largest share of
+// the image to the smallest. Similar colors are grouped using the quantization
+// method in opts, so the returned colors are the averages of the groups. The
+// shares are fractions of the pixels that aren't ignored, so they add up to
+// 1. Uses the default options if opts is nil. Returns an error if every pixel
+// is ignored.
+func DominantColors(pic image.Image,
+	opts *DominantColorOptions) ([]DominantColor, error) {
+	if opts == nil {
+		opts = &DominantColorOptions{}
+	}
+	count := opts.Count
+	if count == 0 {
+		count = 5
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("The number of colors can't be negative")
+	}
+	colors, _ := countColors(pic, func(c color.NRGBA) bool {
+		return c.A >= opts.MinAlpha
+	})
+	if (opts.MaxLightness > 0) || (opts.MinLightness > 0) {
+		kept := colors[:0]
+		for _, c := range colors {
+			l := weightedColorToLab(c.c)[0]
+			if (opts.MaxLightness > 0) && (l > opts.MaxLightness) {
+				continue
+			}
+			if (opts.MinLightness > 0) && (l < opts.MinLightness) {
+				continue
+			}
+			kept = append(kept, c)
+		}
+		colors = kept
+	}
+	total := 0
+	for _, c := range colors {
+		total += c.count
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("The image doesn't contain any pixels that " +
+			"aren't ignored")
+	}
+	quantized, e := quantizeColors(colors, count, opts.Method)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := make([]DominantColor, len(quantized))
+	for i := range quantized {
+		toReturn[i] = DominantColor{
+			Color: quantized[i].rgba(),
+			Share: float64(quantized[i].count) / float64(total),
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns a w x h image containing a vertical stripe, or swatch, of each
+// color, from left to right. If proportional is true, each swatch's width is
+// proportional to its color's share. Otherwise, the swatches have equal
+// widths. The image is a CompositeImage containing an *image.RGBA for each
+// swatch.
+func RenderSwatches(colors []DominantColor, w, h int,
+	proportional bool) (*CompositeImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("At least one color is required")
+	}
+	totalShare := 0.0
+	for _, c := range colors {
+		if c.Share < 0 {
+			return nil, fmt.Errorf("Color shares can't be negative")
+		}
+		totalShare += c.Share
+	}
+	if proportional && (totalShare <= 0) {
+		return nil, fmt.Errorf("The colors' shares add up to 0")
+	}
+	toReturn := NewCompositeImage()
+	// Each swatch ends where the running total of its share would end, so
+	// rounding doesn't leave a gap at the right edge.
+	startX := 0
+	cumulative := 0.0
+	for i, c := range colors {
+		if proportional {
+			cumulative += c.Share / totalShare
+		} else {
+			cumulative = float64(i+1) / float64(len(colors))
+		}
+		endX := int(math.Round(cumulative * float64(w)))
+		if i == (len(colors) - 1) {
+			endX = w
+		}
+		if endX <= startX {
+			continue
+		}
+		swatch := image.NewRGBA(image.Rect(0, 0, endX-startX, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < swatch.Rect.Dx(); x++ {
+				swatch.SetRGBA(x, y, c.Color)
+			}
+		}
+		e := toReturn.AddImage(swatch, image.Pt(startX, 0))
+		if e != nil {
+			return nil, fmt.Errorf("Error adding swatch %d: %w", i, e)
+		}
+		startX = endX
+	}
+	return toReturn, nil
+}
@@ -190,6 +190,83 @@ func (w *rectangleWalker) Next() image.Point {
 	return w.edges[w.segment].Next()
 }
 
+// Implements the ShapeWalker interface for a filled shape. Visits each point
+// in the shape's bounding rectangle, from left to right and top to bottom,
+// skipping any that the shape doesn't contain.
+type filledShapeWalker struct {
+	bounds   image.Rectangle
+	contains func(p image.Point) bool
+	current  image.Point
+}
+
+// Moves the current point forward until it's contained in the shape, or past
+// the bottom of the bounding rectangle.
+func (w *filledShapeWalker) advance() {
+	for w.current.Y < w.bounds.Max.Y {
+		if w.current.X >= w.bounds.Max.X {
+			w.current.X = w.bounds.Min.X
+			w.current.Y++
+			continue
+		}
+		if w.contains(w.current) {
+			return
+		}
+		w.current.X++
+	}
+}
+
+func (w *filledShapeWalker) Reset() {
+	w.current = w.bounds.Min
+	w.advance()
+}
+
+func (w *filledShapeWalker) Done() bool {
+	return w.current.Y >= w.bounds.Max.Y
+}
+
+func (w *filledShapeWalker) Next() image.Point {
+	toReturn := w.current
+	if !w.Done() {
+		w.current.X++
+		w.advance()
+	}
+	return toReturn
+}
+
+// Returns a ShapeWalker that visits every point within the given radius of
+// the center point.
+func GetDiskWalker(center image.Point, radius int) ShapeWalker {
+	if radius < 0 {
+		radius = -radius
+	}
+	r2 := radius * radius
+	toReturn := &filledShapeWalker{
+		bounds: image.Rect(center.X-radius, center.Y-radius,
+			center.X+radius+1, center.Y+radius+1),
+		contains: func(p image.Point) bool {
+			dx := p.X - center.X
+			dy := p.Y - center.Y
+			return (dx*dx + dy*dy) <= r2
+		},
+	}
+	toReturn.Reset()
+	return toReturn
+}
+
+// Returns a ShapeWalker that visits every point in the given rectangle. Unlike
+// GetRectangleWalker, this fills the rectangle rather than tracing its
+// outline, and doesn't include the points on its Max edges.
+func GetFilledRectangleWalker(r image.Rectangle) ShapeWalker {
+	toReturn := &filledShapeWalker{
+		bounds: r.Canon(),
+		contains: func(p image.Point) bool {
+			return true
+		},
+	}
+	toReturn.Reset()
+	return toReturn
+}
+
 // Returns an image containing a picture of an upward-pointing arrow with the
 // given color. The image may be an arbitrary, small, resolution, so use
 // the ResizeImage function if you want it of a specific resolution. The
@@ -290,6 +367,9 @@ type VoronoiPixel struct {
 	// of its current seed pixel. If it is a seed, this contains its own
 	// location.
 	SeedLocation image.Point
+	// The weight of the pixel's current seed. Only used by weighted Voronoi
+	// diagrams, and set from VoronoiOptions.SeedWeight when a fill starts.
+	SeedWeight float32
 }
 
 // Returned by VoronoiImage.At(...) for all cases when the pixel is out of
@@ -320,6 +400,9 @@ type VoronoiImage struct {
 	W, H int
 	// Must contain WxH VoronoiPixels
 	Pixels []VoronoiPixel
+	// Controls how distances to seeds are computed. May be nil, in which case
+	// unweighted Euclidean distances are used.
+	Options *VoronoiOptions
 }
 
 func (v *VoronoiImage) Bounds() image.Rectangle {
@@ -341,38 +424,105 @@ func (v *VoronoiImage) At(x, y int) *VoronoiPixel {
 	return &(v.Pixels[y*w+x])
 }
 
+// Returns a new, empty, VoronoiImage with the given dimensions. Add seeds
+// using AddSeed before calling JumpFloodFill.
+func NewVoronoiImage(w, h int) (*VoronoiImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	return &VoronoiImage{
+		W:      w,
+		H:      h,
+		Pixels: make([]VoronoiPixel, w*h),
+	}, nil
+}
+
+// Marks the pixel at p as a seed with the given color. Returns an error if p
+// is outside of the image. Replaces the color of p if it's already a seed.
+func (v *VoronoiImage) AddSeed(p image.Point, c color.Color) error {
+	if !p.In(v.Bounds()) {
+		return fmt.Errorf("Seed location %s is outside of the image", p)
+	}
+	pixel := &(v.Pixels[p.Y*v.W+p.X])
+	pixel.IsSeed = true
+	pixel.IsDefined = true
+	pixel.Color = c
+	pixel.SeedLocation = p
+	return nil
+}
+
+// Returns the distance from (x, y) to the seed of the given pixel, which must
+// be defined. The result is only intended for comparisons against other
+// results from this function; for example, it's squared for the default
+// Euclidean metric.
+func (v *VoronoiImage) seedDistance(x, y int, p *VoronoiPixel) float32 {
+	dx := float32(x - p.SeedLocation.X)
+	dy := float32(y - p.SeedLocation.Y)
+	opts := v.Options
+	if (opts == nil) || ((opts.Metric == nil) &&
+		(opts.Weighting == UnweightedVoronoi)) {
+		return (dx * dx) + (dy * dy)
+	}
+	metric := opts.Metric
+	if metric == nil {
+		metric = EuclideanDistance
+	}
+	d := metric(dx, dy)
+	switch opts.Weighting {
+	case MultiplicativeWeighting:
+		return d / p.SeedWeight
+	case AdditiveWeighting:
+		return d - p.SeedWeight
+	case PowerWeighting:
+		return (d * d) - p.SeedWeight
+	}
+	return d
+}
+
+// Returns the pixel at (x, y) in src, which must contain WxH pixels, or an
+// undefined pixel if (x, y) is out of bounds.
+func (v *VoronoiImage) pixelIn(src []VoronoiPixel, x, y int) *VoronoiPixel {
+	if (x < 0) || (x >= v.W) || (y < 0) || (y >= v.H) {
+		return &undefinedPixel
+	}
+	return &(src[y*v.W+x])
+}
+
 // Called as part of the inner loop for the jump fill algorithm. Checks the
 // neighbors of the pixel at x, y that are exactly the given stepSize away.
-func (v *VoronoiImage) setSinglePixel(x, y, stepSize int) {
-	p := &(v.Pixels[y*v.W+x])
+// Neighbors are read from src, and the updated pixel is written to dst. The
+// src and dst slices may be the same, in which case the pixel is updated in
+// place.
+func (v *VoronoiImage) setSinglePixel(src, dst []VoronoiPixel, x, y,
+	stepSize int) {
+	index := y*v.W + x
+	dst[index] = src[index]
+	p := &(dst[index])
 	if p.IsSeed {
 		return
 	}
 	var curSeedDistance float32
 	if p.IsDefined {
-		dx := float32(x - p.SeedLocation.X)
-		dy := float32(y - p.SeedLocation.Y)
-		curSeedDistance = (dx * dx) + (dy * dy)
+		curSeedDistance = v.seedDistance(x, y, p)
 	}
 	for j := -1; j <= 1; j++ {
 		for i := -1; i <= 1; i++ {
 			if (i == 0) && (j == 0) {
 				continue
 			}
-			target := v.At(x+(i*stepSize), y+(j*stepSize))
+			target := v.pixelIn(src, x+(i*stepSize), y+(j*stepSize))
 			if !target.IsDefined {
 				// The target pixel doesn't have a color yet.
 				continue
 			}
-			dx := float32(x - target.SeedLocation.X)
-			dy := float32(y - target.SeedLocation.Y)
-			distToTargetSeed := (dx * dx) + (dy * dy)
+			distToTargetSeed := v.seedDistance(x, y, target)
 			if !p.IsDefined {
 				// This is the first time this pixel has "seen" a defined
 				// pixel.
 				p.IsDefined = true
 				p.Color = target.Color
 				p.SeedLocation = target.SeedLocation
+				p.SeedWeight = target.SeedWeight
 				curSeedDistance = distToTargetSeed
 				continue
 			}
@@ -383,34 +533,236 @@ func (v *VoronoiImage) setSinglePixel(x, y, stepSize int) {
 			// The target's seed is closer to our current location than our
 			// current seed, so take its color and location.
 			p.SeedLocation = target.SeedLocation
+			p.SeedWeight = target.SeedWeight
 			p.Color = target.Color
 			curSeedDistance = distToTargetSeed
 		}
 	}
 }
 
+// Returns the sequence of step sizes used by the Jump-Flooding algorithm,
+// including any extra passes required by the selected variant.
+func (v *VoronoiImage) jumpFloodSteps() []int {
+	stepSize := v.W
+	if v.H > stepSize {
+		stepSize = v.H
+	}
+	stepSize = stepSize >> 1
+	toReturn := make([]int, 0, 32)
+	variant := StandardJumpFlood
+	if v.Options != nil {
+		variant = v.Options.Variant
+	}
+	if variant == OnePlusJumpFlood {
+		toReturn = append(toReturn, 1)
+	}
+	for stepSize > 0 {
+		toReturn = append(toReturn, stepSize)
+		stepSize = stepSize >> 1
+	}
+	switch variant {
+	case JumpFloodPlusOne:
+		toReturn = append(toReturn, 1)
+	case JumpFloodPlusTwo:
+		toReturn = append(toReturn, 2, 1)
+	}
+	return toReturn
+}
+
+// Validates the image's options, if any, and sets the SeedWeight of every
+// defined pixel using the options' SeedWeight function. Called before running
+// the Jump-Flooding algorithm.
+func (v *VoronoiImage) prepareFill() error {
+	opts := v.Options
+	if opts == nil {
+		return nil
+	}
+	e := opts.validate()
+	if e != nil {
+		return fmt.Errorf("Invalid Voronoi options: %w", e)
+	}
+	if opts.Weighting == UnweightedVoronoi {
+		return nil
+	}
+	for i := range v.Pixels {
+		p := &(v.Pixels[i])
+		if !p.IsDefined {
+			continue
+		}
+		seed := p.SeedLocation
+		weight := opts.SeedWeight(seed.X, seed.Y)
+		if (opts.Weighting == MultiplicativeWeighting) && !(weight > 0) {
+			return fmt.Errorf("Multiplicative seed weights must be "+
+				"positive; got %f for the seed at %s", weight, seed)
+		}
+		p.SeedWeight = weight
+	}
+	return nil
+}
+
 // Runs the Jump-Flod fill algorithm, returning an error if one occurs.
 func (v *VoronoiImage) JumpFloodFill() error {
+	e := v.prepareFill()
+	if e != nil {
+		return e
+	}
 	w := v.W
 	h := v.H
-	stepSize := w
-	if h > stepSize {
-		stepSize = h
-	}
-	stepSize = stepSize >> 1
-	for stepSize > 0 {
+	for _, stepSize := range v.jumpFloodSteps() {
 		for y := 0; y < h; y++ {
 			for x := 0; x < w; x++ {
-				v.setSinglePixel(x, y, stepSize)
+				v.setSinglePixel(v.Pixels, v.Pixels, x, y, stepSize)
 			}
 		}
-		stepSize = stepSize >> 1
 	}
-	// TODO: Try the additional 1-wide step before or after this loop, as
-	// mentioned in the wikipedia article.
 	return nil
 }
 
+// Returns the distance between two points, given the differences between
+// their X and Y coordinates. Used to customize the distances computed by
+// VoronoiFillWithOptions.
+type DistanceMetric func(dx, dy float32) float32
+
+// A DistanceMetric returning the ordinary straight-line distance.
+func EuclideanDistance(dx, dy float32) float32 {
+	return float32(math.Sqrt(float64((dx * dx) + (dy * dy))))
+}
+
+// A DistanceMetric returning the "taxicab" distance: the sum of the absolute
+// differences in each coordinate.
+func ManhattanDistance(dx, dy float32) float32 {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
+
+// A DistanceMetric returning the largest absolute difference in either
+// coordinate.
+func ChebyshevDistance(dx, dy float32) float32 {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// Returns a DistanceMetric computing the Minkowski distance of order p. A p
+// of 1 is equivalent to the Manhattan distance, and a p of 2 is equivalent to
+// the Euclidean distance. The p value should be at least 1.
+func MinkowskiDistance(p float64) DistanceMetric {
+	return func(dx, dy float32) float32 {
+		sum := math.Pow(math.Abs(float64(dx)), p) +
+			math.Pow(math.Abs(float64(dy)), p)
+		return float32(math.Pow(sum, 1.0/p))
+	}
+}
+
+// Specifies how seed weights modify the distance to each seed when computing a
+// Voronoi diagram.
+type VoronoiWeighting int
+
+const (
+	// Seed weights are ignored.
+	UnweightedVoronoi VoronoiWeighting = iota
+	// The distance to each seed is divided by the seed's weight. Weights must
+	// be positive.
+	MultiplicativeWeighting
+	// The seed's weight is subtracted from the distance to each seed.
+	AdditiveWeighting
+	// The seed's weight is subtracted from the squared distance to each seed,
+	// producing a power diagram.
+	PowerWeighting
+)
+
+func (w VoronoiWeighting) String() string {
+	switch w {
+	case UnweightedVoronoi:
+		return "unweighted"
+	case MultiplicativeWeighting:
+		return "multiplicative"
+	case AdditiveWeighting:
+		return "additive"
+	case PowerWeighting:
+		return "power"
+	}
+	return fmt.Sprintf("unknown weighting %d", int(w))
+}
+
+// Holds options for customizing a Voronoi fill. The zero value produces an
+// ordinary unweighted Euclidean Voronoi diagram.
+type VoronoiOptions struct {
+	// The metric used to compute distances to seeds. Uses EuclideanDistance if
+	// nil.
+	Metric DistanceMetric
+	// How seed weights are applied to distances.
+	Weighting VoronoiWeighting
+	// Must return the weight of the seed at the given location. Required
+	// unless Weighting is UnweightedVoronoi. Multiplicative weights must be
+	// positive.
+	SeedWeight func(x, y int) float32
+	// Selects extra passes run by the Jump-Flooding algorithm to reduce the
+	// number of misassigned pixels.
+	Variant JumpFloodVariant
+}
+
+// Selects a variant of the Jump-Flooding algorithm. The variants add extra
+// passes, with small step sizes, that fix some of the errors made by the
+// standard algorithm, at a slight cost in performance.
+type JumpFloodVariant int
+
+const (
+	// The standard algorithm, with step sizes of N/2, N/4, ..., 1.
+	StandardJumpFlood JumpFloodVariant = iota
+	// "JFA+1": Adds a pass with a step size of 1 after the standard passes.
+	JumpFloodPlusOne
+	// "JFA+2": Adds passes with step sizes of 2 and 1 after the standard
+	// passes.
+	JumpFloodPlusTwo
+	// "1+JFA": Adds a pass with a step size of 1 before the standard passes.
+	OnePlusJumpFlood
+)
+
+func (v JumpFloodVariant) String() string {
+	switch v {
+	case StandardJumpFlood:
+		return "JFA"
+	case JumpFloodPlusOne:
+		return "JFA+1"
+	case JumpFloodPlusTwo:
+		return "JFA+2"
+	case OnePlusJumpFlood:
+		return "1+JFA"
+	}
+	return fmt.Sprintf("unknown JFA variant %d", int(v))
+}
+
+// Returns an error if the options are invalid.
+func (o *VoronoiOptions) validate() error {
+	if (o.Variant < StandardJumpFlood) || (o.Variant > OnePlusJumpFlood) {
+		return fmt.Errorf("Invalid Jump-Flooding variant: %s", o.Variant)
+	}
+	switch o.Weighting {
+	case UnweightedVoronoi:
+		return nil
+	case MultiplicativeWeighting, AdditiveWeighting, PowerWeighting:
+		if o.SeedWeight == nil {
+			return fmt.Errorf("A SeedWeight function is required for %s "+
+				"weighting", o.Weighting)
+		}
+		return nil
+	}
+	return fmt.Errorf("Invalid Voronoi weighting: %s", o.Weighting)
+}
+
 // Fills any zero-valued pixel in pic using the values of the nonzero pixels
 // using the Jump-Flooding Algorithm. See:
 // https://en.wikipedia.org/wiki/Jump_flooding_algorithm
@@ -419,6 +771,14 @@ func (v *VoronoiImage) JumpFloodFill() error {
 // Modifies the input image. Requires an isSeed function that returns true if
 // called on a pixel coordinate that's a seed for the resulting image.
 func VoronoiFill(m image.Image, isSeed func(x, y int) bool) error {
+	return VoronoiFillWithOptions(m, isSeed, nil)
+}
+
+// The same as VoronoiFill, but allows choosing a different distance metric or
+// weighting scheme. The options may be nil, in which case this behaves
+// exactly like VoronoiFill.
+func VoronoiFillWithOptions(m image.Image, isSeed func(x, y int) bool,
+	opts *VoronoiOptions) error {
 	pic, ok := m.(DrawableImage)
 	if !ok {
 		return fmt.Errorf("The given image isn't drawable")
@@ -448,9 +808,10 @@ func VoronoiFill(m image.Image, isSeed func(x, y int) bool) error {
 		}
 	}
 	voronoiImage := &VoronoiImage{
-		Pixels: pixels,
-		W:      w,
-		H:      h,
+		Pixels:  pixels,
+		W:       w,
+		H:       h,
+		Options: opts,
 	}
 
 	// Actually run the fill.
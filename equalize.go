@@ -0,0 +1,287 @@
+package image_utils
+
+// This file contains global histogram equalization and contrast limited
+// adaptive histogram equalization (CLAHE), for grayscale images and for the
+// lightness of color images.
+
+import (
+	"fmt"
+)
+
+// The number of bins in the histograms used for equalization.
+const equalizationBins = 256
+
+// Holds the cumulative distribution of a histogram, normalized so the first
+// entry is 0 and the last is 1. It has one more entry than the histogram has
+// bins, and is treated as piecewise linear within each bin so that mapping
+// values through it doesn't quantize them.
+type equalizationMap []float32
+
+// Returns the equalizationMap for the given bin counts.
+func newEqualizationMap(counts []float64) equalizationMap {
+	toReturn := make(equalizationMap, len(counts)+1)
+	total := 0.0
+	for i, count := range counts {
+		total += count
+		toReturn[i+1] = float32(total)
+	}
+	if total > 0 {
+		for i := range toReturn {
+			toReturn[i] /= float32(total)
+		}
+	}
+	return toReturn
+}
+
+// Returns the histogram bin containing v, which must be in [0, 1].
+func equalizationBin(v float32) int {
+	bin := int(v * equalizationBins)
+	if bin >= equalizationBins {
+		bin = equalizationBins - 1
+	}
+	return bin
+}
+
+// Maps v, which must be in [0, 1], through the cumulative distribution.
+func (m equalizationMap) apply(v float32) float32 {
+	position := v * equalizationBins
+	bin := equalizationBin(v)
+	t := position - float32(bin)
+	return m[bin] + (m[bin+1]-m[bin])*t
+}
+
+// Clamps each value in the plane to [0, 1].
+func clampPlane(values []float32) {
+	for i, v := range values {
+		values[i] = clamp32(v)
+	}
+}
+
+// Applies global histogram equalization to the values, which are clamped to
+// [0, 1] first.
+func equalizePlane(values []float32) {
+	clampPlane(values)
+	counts := make([]float64, equalizationBins)
+	for _, v := range values {
+		counts[equalizationBin(v)]++
+	}
+	m := newEqualizationMap(counts)
+	for i, v := range values {
+		values[i] = m.apply(v)
+	}
+}
+
+// Holds the parameters for contrast limited adaptive histogram equalization.
+type CLAHEOptions struct {
+	// The number of tiles the image is divided into horizontally and
+	// vertically. Each tile is equalized separately. Both default to 8 if 0,
+	// and are limited to the image's dimensions.
+	TilesX, TilesY int
+	// Limits how much the contrast can be increased, as a multiple of the
+	// average number of pixels per histogram bin. Counts above the limit are
+	// redistributed evenly among all bins before equalizing. Must be at least
+	// 1, where 1 disables equalization. Defaults to 2 if 0.
+	ClipLimit float64
+}
+
+// Returns the center of each of the given number of tiles spanning size
+// pixels, along with the boundaries of the tiles.
+func tileLayout(tiles, size int) ([]float32, []int) {
+	centers := make([]float32, tiles)
+	edges := make([]int, tiles+1)
+	for i := range edges {
+		edges[i] = i * size / tiles
+	}
+	for i := range centers {
+		centers[i] = float32(edges[i]+edges[i+1]) / 2.0
+	}
+	return centers, edges
+}
+
+// Returns the indices of the tiles whose centers surround pos, and the
+// fraction of the way pos is from the first center to the second. Positions
+// beyond the outer centers use only the outermost tile.
+func surroundingTiles(centers []float32, pos float32) (int, int, float32) {
+	if pos <= centers[0] {
+		return 0, 0, 0
+	}
+	last := len(centers) - 1
+	if pos >= centers[last] {
+		return last, last, 0
+	}
+	i := 0
+	for centers[i+1] < pos {
+		i++
+	}
+	return i, i + 1, (pos - centers[i]) / (centers[i+1] - centers[i])
+}
+
+// Applies CLAHE to a w x h plane of values, which are clamped to [0, 1]
+// first.
+func clahePlane(values []float32, w, h int, opts *CLAHEOptions) error {
+	if opts == nil {
+		opts = &CLAHEOptions{}
+	}
+	tilesX := opts.TilesX
+	if tilesX == 0 {
+		tilesX = 8
+	}
+	tilesY := opts.TilesY
+	if tilesY == 0 {
+		tilesY = 8
+	}
+	if (tilesX < 0) || (tilesY < 0) {
+		return fmt.Errorf("The number of tiles can't be negative")
+	}
+	if tilesX > w {
+		tilesX = w
+	}
+	if tilesY > h {
+		tilesY = h
+	}
+	clipLimit := opts.ClipLimit
+	if clipLimit == 0 {
+		clipLimit = 2.0
+	}
+	if !(clipLimit >= 1) {
+		return fmt.Errorf("The clip limit must be at least 1, got %f",
+			clipLimit)
+	}
+	clampPlane(values)
+	centersX, edgesX := tileLayout(tilesX, w)
+	centersY, edgesY := tileLayout(tilesY, h)
+
+	// Compute the clipped equalization map for each tile.
+	maps := make([]equalizationMap, tilesX*tilesY)
+	counts := make([]float64, equalizationBins)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			for i := range counts {
+				counts[i] = 0
+			}
+			for y := edgesY[ty]; y < edgesY[ty+1]; y++ {
+				for x := edgesX[tx]; x < edgesX[tx+1]; x++ {
+					counts[equalizationBin(values[y*w+x])]++
+				}
+			}
+			pixels := float64((edgesX[tx+1] - edgesX[tx]) *
+				(edgesY[ty+1] - edgesY[ty]))
+			limit := clipLimit * pixels / equalizationBins
+			excess := 0.0
+			for i, count := range counts {
+				if count > limit {
+					excess += count - limit
+					counts[i] = limit
+				}
+			}
+			for i := range counts {
+				counts[i] += excess / equalizationBins
+			}
+			maps[ty*tilesX+tx] = newEqualizationMap(counts)
+		}
+	}
+
+	// Bilinearly interpolate between the maps of the four tiles whose
+	// centers surround each pixel, to avoid visible tile boundaries.
+	for y := 0; y < h; y++ {
+		ty0, ty1, fy := surroundingTiles(centersY, float32(y)+0.5)
+		for x := 0; x < w; x++ {
+			tx0, tx1, fx := surroundingTiles(centersX, float32(x)+0.5)
+			v := values[y*w+x]
+			top := maps[ty0*tilesX+tx0].apply(v)*(1-fx) +
+				maps[ty0*tilesX+tx1].apply(v)*fx
+			bottom := maps[ty1*tilesX+tx0].apply(v)*(1-fx) +
+				maps[ty1*tilesX+tx1].apply(v)*fx
+			values[y*w+x] = top*(1-fy) + bottom*fy
+		}
+	}
+	return nil
+}
+
+// Spreads the image's values so that their histogram is approximately flat,
+// increasing the contrast of the most common values. Values are clamped to
+// [0, 1] first.
+func (f *FloatGrayscaleImage) EqualizeHistogram() {
+	equalizePlane(f.Pixels)
+}
+
+// Applies contrast limited adaptive histogram equalization to the image,
+// which equalizes each region of the image separately, while limiting how
+// much noise in flat regions is amplified. Values are clamped to [0, 1] first.
+// Uses the default options if opts is nil.
+func (f *FloatGrayscaleImage) CLAHE(opts *CLAHEOptions) error {
+	return clahePlane(f.Pixels, f.W, f.H, opts)
+}
+
+// Returns the lightness component of each pixel in the image, from 0 to 1.
+func (h *HSLImage) lightnessPlane() []float32 {
+	toReturn := make([]float32, h.W*h.H)
+	for i := range toReturn {
+		toReturn[i] = float32(h.Pixels[3*i+2]) / float32(0xffff)
+	}
+	return toReturn
+}
+
+// Sets the lightness component of each pixel in the image.
+func (h *HSLImage) setLightnessPlane(values []float32) {
+	for i, v := range values {
+		h.Pixels[3*i+2] = scaleTo16Bit(float64(v))
+	}
+}
+
+// Like FloatGrayscaleImage.EqualizeHistogram, but equalizes the lightness
+// component of each pixel, leaving hue and saturation unchanged.
+func (h *HSLImage) EqualizeLightness() {
+	values := h.lightnessPlane()
+	equalizePlane(values)
+	h.setLightnessPlane(values)
+}
+
+// Like FloatGrayscaleImage.CLAHE, but applied to the lightness component of
+// each pixel, leaving hue and saturation unchanged.
+func (h *HSLImage) CLAHE(opts *CLAHEOptions) error {
+	values := h.lightnessPlane()
+	e := clahePlane(values, h.W, h.H, opts)
+	if e != nil {
+		return e
+	}
+	h.setLightnessPlane(values)
+	return nil
+}
+
+// Returns the L component of each pixel in the image, scaled to [0, 1].
+func (l *LabImage) lightnessPlane() []float32 {
+	toReturn := make([]float32, len(l.L))
+	for i, v := range l.L {
+		toReturn[i] = v / 100.0
+	}
+	return toReturn
+}
+
+// Sets the L component of each pixel in the image, from values in [0, 1].
+func (l *LabImage) setLightnessPlane(values []float32) {
+	for i, v := range values {
+		l.L[i] = v * 100.0
+	}
+}
+
+// Like FloatGrayscaleImage.EqualizeHistogram, but equalizes the perceptual
+// lightness of each pixel, leaving the A and B components unchanged. This
+// usually distorts colors less than equalizing an HSLImage's lightness.
+func (l *LabImage) EqualizeLightness() {
+	values := l.lightnessPlane()
+	equalizePlane(values)
+	l.setLightnessPlane(values)
+}
+
+// Like FloatGrayscaleImage.CLAHE, but applied to the perceptual lightness of
+// each pixel, leaving the A and B components unchanged.
+func (l *LabImage) CLAHE(opts *CLAHEOptions) error {
+	values := l.lightnessPlane()
+	e := clahePlane(values, l.W, l.H, opts)
+	if e != nil {
+		return e
+	}
+	l.setLightnessPlane(values)
+	return nil
+}
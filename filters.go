@@ -0,0 +1,315 @@
+package image_utils
+
+// This file contains noise-reduction filters, such as median, rank and
+// bilateral filters, that can be applied to any DrawableImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Holds a copy of an image's pixels as separate floating-point channels. Used
+// by filters that need to keep reading the original pixels while overwriting
+// the image.
+type channelPlanes struct {
+	w, h int
+	// The original bounds of the image the planes were copied from.
+	bounds image.Rectangle
+	// For a FloatGrayscaleImage or FloatColorImage, holds the image's values
+	// as-is, in one or three channels, respectively. Otherwise, holds the
+	// red, green, blue and alpha channels, in that order, each in the range
+	// [0, 1]. Like Go's color.Color interface, the color channels are
+	// alpha-premultiplied.
+	c [][]float32
+}
+
+// Copies the pixels of pic into a new channelPlanes struct.
+func newChannelPlanes(pic image.Image) (*channelPlanes, error) {
+	bounds := pic.Bounds().Canon()
+	w := bounds.Dx()
+	h := bounds.Dy()
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	toReturn := &channelPlanes{
+		w:      w,
+		h:      h,
+		bounds: bounds,
+	}
+	switch p := pic.(type) {
+	case *FloatGrayscaleImage:
+		toReturn.c = [][]float32{make([]float32, w*h)}
+		copy(toReturn.c[0], p.Pixels)
+		return toReturn, nil
+	case *FloatColorImage:
+		toReturn.c = make([][]float32, 3)
+		for i := range toReturn.c {
+			toReturn.c[i] = make([]float32, w*h)
+		}
+		for i, v := range p.Pixels[:w*h] {
+			toReturn.c[0][i] = v.R
+			toReturn.c[1][i] = v.G
+			toReturn.c[2][i] = v.B
+		}
+		return toReturn, nil
+	}
+	toReturn.c = make([][]float32, 4)
+	for i := range toReturn.c {
+		toReturn.c[i] = make([]float32, w*h)
+	}
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := pic.At(x, y).RGBA()
+			toReturn.c[0][i] = float32(r) / 0xffff
+			toReturn.c[1][i] = float32(g) / 0xffff
+			toReturn.c[2][i] = float32(b) / 0xffff
+			toReturn.c[3][i] = float32(a) / 0xffff
+			i++
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns the color at the given index in the planes, clamping each channel
+// so that the result is a valid premultiplied color.
+func (p *channelPlanes) colorAt(i int) color.RGBA64 {
+	a := clamp32(p.c[3][i])
+	limit := func(v float32) uint16 {
+		v = clamp32(v)
+		if v > a {
+			v = a
+		}
+		return uint16(v * 0xffff)
+	}
+	return color.RGBA64{
+		R: limit(p.c[0][i]),
+		G: limit(p.c[1][i]),
+		B: limit(p.c[2][i]),
+		A: uint16(a * 0xffff),
+	}
+}
+
+// Overwrites the pixels in pic with the contents of the planes. The planes
+// must have been created from pic, or an image of the same type with the same
+// bounds.
+func (p *channelPlanes) writeTo(pic DrawableImage) {
+	switch dst := pic.(type) {
+	case *FloatGrayscaleImage:
+		copy(dst.Pixels, p.c[0])
+		return
+	case *FloatColorImage:
+		for i := range dst.Pixels[:p.w*p.h] {
+			dst.Pixels[i] = FloatColor{
+				R: p.c[0][i],
+				G: p.c[1][i],
+				B: p.c[2][i],
+			}
+		}
+		return
+	}
+	i := 0
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			pic.Set(p.bounds.Min.X+x, p.bounds.Min.Y+y, p.colorAt(i))
+			i++
+		}
+	}
+}
+
+// Runs a rank filter on a single w x h channel using a sliding histogram, so
+// the cost per pixel only grows linearly with the radius. The rank must be in
+// [0, 1], where 0 selects the minimum, 0.5 the median, and 1 the maximum. The
+// histogram counts the channel's distinct values rather than quantized levels,
+// so each output value is exactly one of the values in its window.
+func rankFilterChannel(src []float32, w, h, radius int,
+	rank float64) []float32 {
+	// Replace each value with its index among the sorted distinct values.
+	order := make([]int, len(src))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return src[order[a]] < src[order[b]]
+	})
+	levels := make([]int, len(src))
+	distinct := make([]float32, 0, len(src))
+	for i, index := range order {
+		if (i == 0) || (src[index] != distinct[len(distinct)-1]) {
+			distinct = append(distinct, src[index])
+		}
+		levels[index] = len(distinct) - 1
+	}
+
+	// The histogram has two levels: counts holds the number of pixels in the
+	// window with each value, and blockCounts holds the totals for blocks of
+	// consecutive values, so finding a rank doesn't need to visit every value.
+	blockSize := int(math.Sqrt(float64(len(distinct)))) + 1
+	counts := make([]int, len(distinct))
+	blockCounts := make([]int, len(distinct)/blockSize+1)
+	dst := make([]float32, len(src))
+	for y := 0; y < h; y++ {
+		minY := y - radius
+		if minY < 0 {
+			minY = 0
+		}
+		maxY := y + radius
+		if maxY >= h {
+			maxY = h - 1
+		}
+		count := 0
+		updateColumn := func(x, delta int) {
+			if (x < 0) || (x >= w) {
+				return
+			}
+			for j := minY; j <= maxY; j++ {
+				level := levels[j*w+x]
+				counts[level] += delta
+				blockCounts[level/blockSize] += delta
+				count += delta
+			}
+		}
+		for x := 0; x < radius; x++ {
+			updateColumn(x, 1)
+		}
+		for x := 0; x < w; x++ {
+			updateColumn(x+radius, 1)
+			updateColumn(x-radius-1, -1)
+			target := int(rank*float64(count-1) + 0.5)
+			block := 0
+			for target >= blockCounts[block] {
+				target -= blockCounts[block]
+				block++
+			}
+			level := block * blockSize
+			for target >= counts[level] {
+				target -= counts[level]
+				level++
+			}
+			dst[y*w+x] = distinct[level]
+		}
+		// Remove the columns still in the window, leaving the histogram empty
+		// for the next row.
+		for x := w - radius - 1; x < w; x++ {
+			updateColumn(x, -1)
+		}
+	}
+	return dst
+}
+
+// Replaces each pixel in pic with the value at the given rank among the
+// pixels in the surrounding square window with the given radius. Each channel
+// is filtered independently. The rank must be between 0 and 1, where 0 is the
+// darkest value in the window, 0.5 is the median, and 1 is the brightest.
+// FloatGrayscaleImage and FloatColorImage pixels are filtered directly, so
+// their values are preserved exactly, even outside the range [0, 1].
+func RankFilter(pic DrawableImage, radius int, rank float64) error {
+	if radius <= 0 {
+		return fmt.Errorf("The filter radius must be positive, got %d", radius)
+	}
+	if (rank < 0) || (rank > 1) || math.IsNaN(rank) {
+		return fmt.Errorf("The rank must be between 0 and 1, got %f", rank)
+	}
+	planes, e := newChannelPlanes(pic)
+	if e != nil {
+		return fmt.Errorf("Error reading image pixels: %w", e)
+	}
+	for i := range planes.c {
+		planes.c[i] = rankFilterChannel(planes.c[i], planes.w, planes.h,
+			radius, rank)
+	}
+	planes.writeTo(pic)
+	return nil
+}
+
+// Applies a median filter with the given radius to pic. Uses a sliding
+// histogram, so it remains fast at large radii.
+func MedianFilter(pic DrawableImage, radius int) error {
+	return RankFilter(pic, radius, 0.5)
+}
+
+// Replaces each pixel with the minimum of each channel in the window with the
+// given radius.
+func MinFilter(pic DrawableImage, radius int) error {
+	return RankFilter(pic, radius, 0.0)
+}
+
+// Replaces each pixel with the maximum of each channel in the window with the
+// given radius.
+func MaxFilter(pic DrawableImage, radius int) error {
+	return RankFilter(pic, radius, 1.0)
+}
+
+// Applies an edge-preserving bilateral filter to pic. The spatialSigma is the
+// standard deviation, in pixels, of the Gaussian weight applied to distance,
+// and rangeSigma is the standard deviation of the weight applied to color
+// differences, where each channel is in the range [0, 1]. Like RankFilter,
+// FloatGrayscaleImage and FloatColorImage pixels are filtered directly, and
+// rangeSigma applies to their values as-is.
+func BilateralFilter(pic DrawableImage, spatialSigma,
+	rangeSigma float64) error {
+	if !(spatialSigma > 0) || !(rangeSigma > 0) {
+		return fmt.Errorf("The bilateral filter sigmas must be positive, "+
+			"got %f and %f", spatialSigma, rangeSigma)
+	}
+	planes, e := newChannelPlanes(pic)
+	if e != nil {
+		return fmt.Errorf("Error reading image pixels: %w", e)
+	}
+	w := planes.w
+	h := planes.h
+	radius := int(math.Ceil(2.0 * spatialSigma))
+	size := 2*radius + 1
+	spatialWeights := make([]float32, size*size)
+	for j := -radius; j <= radius; j++ {
+		for i := -radius; i <= radius; i++ {
+			d2 := float64(i*i + j*j)
+			spatialWeights[(j+radius)*size+(i+radius)] = float32(math.Exp(
+				-d2 / (2.0 * spatialSigma * spatialSigma)))
+		}
+	}
+	rangeScale := float32(-1.0 / (2.0 * rangeSigma * rangeSigma))
+	filtered := make([][]float32, len(planes.c))
+	for i := range filtered {
+		filtered[i] = make([]float32, w*h)
+	}
+	src := planes.c
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			center := y*w + x
+			var sums [4]float32
+			weightSum := float32(0)
+			for j := y - radius; j <= y+radius; j++ {
+				if (j < 0) || (j >= h) {
+					continue
+				}
+				for i := x - radius; i <= x+radius; i++ {
+					if (i < 0) || (i >= w) {
+						continue
+					}
+					k := j*w + i
+					d2 := float32(0)
+					for c := range src {
+						diff := src[c][k] - src[c][center]
+						d2 += diff * diff
+					}
+					weight := spatialWeights[(j-y+radius)*size+(i-x+radius)] *
+						float32(math.Exp(float64(d2*rangeScale)))
+					for c := range src {
+						sums[c] += weight * src[c][k]
+					}
+					weightSum += weight
+				}
+			}
+			for c := range filtered {
+				filtered[c][center] = sums[c] / weightSum
+			}
+		}
+	}
+	planes.c = filtered
+	planes.writeTo(pic)
+	return nil
+}
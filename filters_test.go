@@ -0,0 +1,91 @@
+package image_utils
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns the largest absolute difference between corresponding values in a
+// and b.
+func maxDifference(a, b []float32) float64 {
+	toReturn := 0.0
+	for i := range a {
+		d := math.Abs(float64(a[i] - b[i]))
+		if d > toReturn {
+			toReturn = d
+		}
+	}
+	return toReturn
+}
+
+func TestFilterFloatGrayscaleRange(t *testing.T) {
+	pic, e := NewFloatGrayscaleImage(32, 32)
+	if e != nil {
+		t.Fatalf("Failed creating image: %s", e)
+	}
+	// A sharp edge between two values outside of [0, 1], which an
+	// edge-preserving filter should leave unchanged.
+	for y := 0; y < pic.H; y++ {
+		for x := 0; x < pic.W; x++ {
+			v := float32(-0.75)
+			if x >= (pic.W / 2) {
+				v = 3.15
+			}
+			pic.Pixels[y*pic.W+x] = v
+		}
+	}
+	original := append([]float32{}, pic.Pixels...)
+	e = MedianFilter(pic, 3)
+	if e != nil {
+		t.Fatalf("Failed running median filter: %s", e)
+	}
+	d := maxDifference(original, pic.Pixels)
+	if d != 0 {
+		t.Errorf("Median filter changed values by up to %f", d)
+	}
+	e = BilateralFilter(pic, 2.0, 0.1)
+	if e != nil {
+		t.Fatalf("Failed running bilateral filter: %s", e)
+	}
+	d = maxDifference(original, pic.Pixels)
+	if d > 1.0e-5 {
+		t.Errorf("Bilateral filter changed values by up to %f", d)
+	}
+}
+
+func TestFilterFloatColorRange(t *testing.T) {
+	pic, e := NewFloatColorImage(16, 16)
+	if e != nil {
+		t.Fatalf("Failed creating image: %s", e)
+	}
+	for i := range pic.Pixels {
+		pic.Pixels[i] = FloatColor{
+			R: 2.5,
+			G: -0.5,
+			B: 0.3,
+		}
+	}
+	expected := pic.Pixels[0]
+	e = MaxFilter(pic, 2)
+	if e != nil {
+		t.Fatalf("Failed running max filter: %s", e)
+	}
+	for i, c := range pic.Pixels {
+		if c != expected {
+			t.Fatalf("Max filter changed pixel %d from %#v to %#v", i,
+				expected, c)
+		}
+	}
+	e = BilateralFilter(pic, 1.5, 0.2)
+	if e != nil {
+		t.Fatalf("Failed running bilateral filter: %s", e)
+	}
+	for i, c := range pic.Pixels {
+		if (math.Abs(float64(c.R-expected.R)) > 1.0e-5) ||
+			(math.Abs(float64(c.G-expected.G)) > 1.0e-5) ||
+			(math.Abs(float64(c.B-expected.B)) > 1.0e-5) {
+			t.Fatalf("Bilateral filter changed pixel %d from %#v to %#v", i,
+				expected, c)
+		}
+	}
+}
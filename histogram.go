@@ -0,0 +1,241 @@
+package image_utils
+
+// This file contains the Histogram type, which counts the distribution of an
+// image's channel values, along with statistics computed from it.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Selects one of the channels counted by a Histogram.
+type HistogramChannel int
+
+const (
+	RedChannel HistogramChannel = iota
+	GreenChannel
+	BlueChannel
+	AlphaChannel
+	// The Rec. 709 luma of each pixel's gamma-encoded color, ignoring alpha.
+	LuminanceChannel
+)
+
+func (c HistogramChannel) String() string {
+	switch c {
+	case RedChannel:
+		return "red"
+	case GreenChannel:
+		return "green"
+	case BlueChannel:
+		return "blue"
+	case AlphaChannel:
+		return "alpha"
+	case LuminanceChannel:
+		return "luminance"
+	}
+	return fmt.Sprintf("unknown histogram channel %d", int(c))
+}
+
+// Counts the number of pixels in an image with each value of each channel.
+// Channel values are normalized to [0, 1], and bin i represents the value
+// i / (BinCount - 1). Each value is counted in the bin representing the
+// closest value. The color channels aren't premultiplied by alpha. The
+// statistics returned by the Histogram's methods are computed from the bins,
+// so their precision depends on the number of bins. The methods taking a
+// HistogramChannel will panic if it's invalid.
+type Histogram struct {
+	// The number of pixels in each bin, indexed by HistogramChannel and then
+	// by bin.
+	Bins [5][]int
+	// The number of bins per channel.
+	BinCount int
+	// The total number of pixels counted.
+	Total int
+}
+
+// Computes and returns the histogram of pic, with the given number of bins
+// per channel, from 2 to 65536. 256 bins represents 8-bit images exactly.
+func NewHistogram(pic image.Image, binCount int) (*Histogram, error) {
+	if (binCount < 2) || (binCount > 65536) {
+		return nil, fmt.Errorf("The number of bins must be from 2 to 65536, "+
+			"got %d", binCount)
+	}
+	bounds := pic.Bounds().Canon()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("Can't compute the histogram of an empty image")
+	}
+	toReturn := &Histogram{
+		BinCount: binCount,
+		Total:    bounds.Dx() * bounds.Dy(),
+	}
+	for i := range toReturn.Bins {
+		toReturn.Bins[i] = make([]int, binCount)
+	}
+	// Maps a value from 0 to 0xffff to a bin, rounding to the nearest.
+	bin := func(v float64) int {
+		return int(math.Round(v * float64(binCount-1) / float64(0xffff)))
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(pic.At(x, y)).(color.NRGBA64)
+			luminance := 0.2126*float64(c.R) + 0.7152*float64(c.G) +
+				0.0722*float64(c.B)
+			toReturn.Bins[RedChannel][bin(float64(c.R))]++
+			toReturn.Bins[GreenChannel][bin(float64(c.G))]++
+			toReturn.Bins[BlueChannel][bin(float64(c.B))]++
+			toReturn.Bins[AlphaChannel][bin(float64(c.A))]++
+			toReturn.Bins[LuminanceChannel][bin(luminance)]++
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns the value, from 0 to 1, represented by the given bin.
+func (h *Histogram) BinValue(bin int) float64 {
+	return float64(bin) / float64(h.BinCount-1)
+}
+
+// Returns the mean value of the channel.
+func (h *Histogram) Mean(c HistogramChannel) float64 {
+	sum := 0.0
+	for i, count := range h.Bins[c] {
+		sum += h.BinValue(i) * float64(count)
+	}
+	return sum / float64(h.Total)
+}
+
+// Returns the standard deviation of the channel's values.
+func (h *Histogram) StandardDeviation(c HistogramChannel) float64 {
+	mean := h.Mean(c)
+	sum := 0.0
+	for i, count := range h.Bins[c] {
+		d := h.BinValue(i) - mean
+		sum += d * d * float64(count)
+	}
+	return math.Sqrt(sum / float64(h.Total))
+}
+
+// Returns the smallest value of the channel such that at least the given
+// fraction of the pixels, from 0 to 1, have a value less than or equal to it.
+func (h *Histogram) Percentile(c HistogramChannel, fraction float64) float64 {
+	target := clamp(fraction) * float64(h.Total)
+	cumulative := 0
+	for i, count := range h.Bins[c] {
+		cumulative += count
+		if (count > 0) && (float64(cumulative) >= target) {
+			return h.BinValue(i)
+		}
+	}
+	return h.Max(c)
+}
+
+// Returns the median value of the channel.
+func (h *Histogram) Median(c HistogramChannel) float64 {
+	return h.Percentile(c, 0.5)
+}
+
+// Returns the smallest value of the channel.
+func (h *Histogram) Min(c HistogramChannel) float64 {
+	for i, count := range h.Bins[c] {
+		if count > 0 {
+			return h.BinValue(i)
+		}
+	}
+	return 0
+}
+
+// Returns the largest value of the channel.
+func (h *Histogram) Max(c HistogramChannel) float64 {
+	bins := h.Bins[c]
+	for i := len(bins) - 1; i >= 0; i-- {
+		if bins[i] > 0 {
+			return h.BinValue(i)
+		}
+	}
+	return 0
+}
+
+// Uses Otsu's method to find the threshold that best separates the channel's
+// values into two classes, by maximizing the variance between the classes.
+// Values less than or equal to the returned threshold are in the lower class.
+func (h *Histogram) OtsuThreshold(c HistogramChannel) float64 {
+	bins := h.Bins[c]
+	total := float64(h.Total)
+	sum := 0.0
+	for i, count := range bins {
+		sum += h.BinValue(i) * float64(count)
+	}
+	best := 0
+	bestVariance := -1.0
+	lowerWeight := 0.0
+	lowerSum := 0.0
+	for i := 0; i < len(bins)-1; i++ {
+		lowerWeight += float64(bins[i])
+		lowerSum += h.BinValue(i) * float64(bins[i])
+		upperWeight := total - lowerWeight
+		if (lowerWeight == 0) || (upperWeight == 0) {
+			continue
+		}
+		d := lowerSum/lowerWeight - (sum-lowerSum)/upperWeight
+		variance := lowerWeight * upperWeight * d * d
+		if variance > bestVariance {
+			best = i
+			bestVariance = variance
+		}
+	}
+	return h.BinValue(best)
+}
+
+// Draws the given channel of the histogram as a bar chart filling dst's
+// bounds, using DrawLine to draw a vertical bar in the given color for each
+// column. The tallest bar fills the full height. Columns covering several bins
+// show the largest of them. Call this once per channel to overlay several
+// channels in one image.
+func DrawHistogram(h *Histogram, channel HistogramChannel, c color.Color,
+	dst DrawableImage) error {
+	if (channel < RedChannel) || (channel > LuminanceChannel) {
+		return fmt.Errorf("Invalid histogram channel: %s", channel)
+	}
+	bins := h.Bins[channel]
+	maxCount := 0
+	for _, count := range bins {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	if maxCount == 0 {
+		return nil
+	}
+	bounds := dst.Bounds().Canon()
+	w := bounds.Dx()
+	height := bounds.Dy()
+	for x := 0; x < w; x++ {
+		firstBin := x * len(bins) / w
+		lastBin := (x + 1) * len(bins) / w
+		if lastBin <= firstBin {
+			lastBin = firstBin + 1
+		}
+		count := 0
+		for _, binCount := range bins[firstBin:lastBin] {
+			if binCount > count {
+				count = binCount
+			}
+		}
+		barHeight := int(math.Round(float64(count) / float64(maxCount) *
+			float64(height)))
+		if barHeight == 0 {
+			continue
+		}
+		// DrawLine walks vertical lines from top to bottom and skips the
+		// bottom endpoint, so starting just below the image draws exactly
+		// barHeight pixels, ending on the bottom row.
+		bottom := image.Pt(bounds.Min.X+x, bounds.Max.Y)
+		e := DrawLine(bottom, bottom.Sub(image.Pt(0, barHeight)), c, dst)
+		if e != nil {
+			return fmt.Errorf("Error drawing histogram column %d: %w", x, e)
+		}
+	}
+	return nil
+}
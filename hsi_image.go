@@ -0,0 +1,193 @@
+package image_utils
+
+// This file contains an implementation of an HSI (hue, saturation, intensity)
+// image, where each channel is stored in a separate 16-bit plane. It's
+// organized the same way as HSVImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Implements the color interface. Stores the H, S, and I components,
+// respectively. *This will panic if the slice doesn't contain at least 3
+// components.* Values after the first 3 are ignored. Each component is a
+// fraction out of 0xffff.
+//
+// Unlike HSL and HSV, HSI's hue is the angle around the axis of the RGB cube,
+// so it differs slightly from the hue of the same color in the other models.
+// Some combinations of components lie outside of the RGB gamut; their RGB
+// components are clamped.
+type HSIColor []uint16
+
+// Utility function to convert the 3 16-bit values to fractional components.
+func (c HSIColor) HSIComponents() (float64, float64, float64) {
+	h := float64(c[0]) / float64(0xffff)
+	s := float64(c[1]) / float64(0xffff)
+	i := float64(c[2]) / float64(0xffff)
+	return h, s, i
+}
+
+func (c HSIColor) String() string {
+	h, s, i := c.HSIComponents()
+	return fmt.Sprintf("(%f, %f, %f)", h, s, i)
+}
+
+// Based on the sector formulas in Gonzalez and Woods' "Digital Image
+// Processing".
+func (c HSIColor) RGBA() (r, g, b, a uint32) {
+	h, s, i := c.HSIComponents()
+	// Each third of the hue circle is handled the same way, with the
+	// components rotated.
+	angle := math.Mod(h, 1.0) * 2.0 * math.Pi
+	sector := int(angle / (2.0 * math.Pi / 3.0))
+	if sector > 2 {
+		sector = 2
+	}
+	angle -= float64(sector) * 2.0 * math.Pi / 3.0
+	low := i * (1.0 - s)
+	high := i * (1.0 + s*math.Cos(angle)/math.Cos(math.Pi/3.0-angle))
+	rest := 3.0*i - (low + high)
+	var r1, g1, b1 float64
+	switch sector {
+	case 0:
+		r1, g1, b1 = high, rest, low
+	case 1:
+		r1, g1, b1 = low, high, rest
+	default:
+		r1, g1, b1 = rest, low, high
+	}
+	r = uint32(scaleTo16Bit(r1))
+	g = uint32(scaleTo16Bit(g1))
+	b = uint32(scaleTo16Bit(b1))
+	a = 0xffff
+	return
+}
+
+// Returns the H, S, and I components of the given color, each scaled to the
+// range [0, 1]. Hue is 0 for grays. Ignores alpha.
+func rgbToHSIComponents(c color.Color) (float64, float64, float64) {
+	r, g, b := colorToRGBFloats(c)
+	i := (r + g + b) / 3.0
+	minValue := math.Min(r, math.Min(g, b))
+	if (i == 0) || (minValue == i) {
+		return 0, 0, i
+	}
+	s := 1.0 - minValue/i
+	numerator := 0.5 * ((r - g) + (r - b))
+	denominator := math.Sqrt((r-g)*(r-g) + (r-b)*(g-b))
+	// Guard against rounding pushing the cosine slightly out of range.
+	cosine := math.Max(-1.0, math.Min(1.0, numerator/denominator))
+	h := math.Acos(cosine) / (2.0 * math.Pi)
+	if b > g {
+		h = 1.0 - h
+	}
+	return wrapHue(h), clamp(s), i
+}
+
+// Converts an arbitrary color to HSI. Returns the original color if it's
+// already an HSIColor.
+func RGBToHSI(c color.Color) HSIColor {
+	hsi, ok := c.(HSIColor)
+	if ok {
+		return hsi
+	}
+	h, s, i := rgbToHSIComponents(c)
+	return HSIColor([]uint16{scaleTo16Bit(h), scaleTo16Bit(s),
+		scaleTo16Bit(i)})
+}
+
+// A color.Model that converts colors to HSIColor values.
+var HSIModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToHSI(c)
+})
+
+// Implements the image interface. Internally uses HSI representation for each
+// pixel, with each component stored in a separate plane.
+type HSIImage struct {
+	// Each pixel's components, with one plane per component, in row-major
+	// order. Each plane must contain W * H values.
+	Hue, Saturation, Intensity []uint16
+	W, H                       int
+}
+
+// Returns the image's planes, in the order used by SetComponent.
+func (h *HSIImage) planes() [3][]uint16 {
+	return [3][]uint16{h.Hue, h.Saturation, h.Intensity}
+}
+
+func (h *HSIImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, h.W, h.H)
+}
+
+func (h *HSIImage) ColorModel() color.Model {
+	return HSIModel
+}
+
+// Returns a copy of the HSIColor at (x, y), or a separate, black, HSIColor
+// if the coordinate is outside of the image boundaries.
+func (h *HSIImage) HSIPixel(x, y int) HSIColor {
+	return HSIColor(planarPixel(h.planes(), h.W, h.H, x, y, [3]uint16{}))
+}
+
+func (h *HSIImage) At(x, y int) color.Color {
+	return h.HSIPixel(x, y)
+}
+
+// Sets the pixel at (x, y) to the given color, converted to HSI. Does nothing
+// if (x, y) is outside of the image.
+func (h *HSIImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= h.W) || (y >= h.H) {
+		return
+	}
+	index := y*h.W + x
+	hsi, ok := c.(HSIColor)
+	if ok {
+		h.Hue[index] = hsi[0]
+		h.Saturation[index] = hsi[1]
+		h.Intensity[index] = hsi[2]
+		return
+	}
+	hue, s, i := rgbToHSIComponents(c)
+	h.Hue[index] = scaleTo16Bit(hue)
+	h.Saturation[index] = scaleTo16Bit(s)
+	h.Intensity[index] = scaleTo16Bit(i)
+}
+
+// Takes another image and sets a component of each of this image's pixels
+// based on the brightness of each pixel in pic. The "componentOffset" must be
+// 0 if setting hue, 1 if setting saturation, and 2 if setting intensity.
+func (h *HSIImage) SetComponent(pic image.Image, componentOffset int) error {
+	return setPlanarComponent(h.planes(), h.W, h.H, pic, componentOffset)
+}
+
+func NewHSIImage(w, h int) (*HSIImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	return &HSIImage{
+		W:          w,
+		H:          h,
+		Hue:        make([]uint16, w*h),
+		Saturation: make([]uint16, w*h),
+		Intensity:  make([]uint16, w*h),
+	}, nil
+}
+
+// Returns a new HSIImage containing a copy of pic, converted to HSI. The new
+// image's top-left corner will be at (0, 0).
+func NewHSIImageFromImage(pic image.Image) (*HSIImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewHSIImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,187 @@
+package image_utils
+
+// This file contains color adjustments that operate on the components of an
+// HSLImage's pixels, each of which can be limited by an optional mask.
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Wraps a hue value into the range [0, 1).
+func wrapHue(h float64) float64 {
+	h = math.Mod(h, 1.0)
+	if h < 0 {
+		h += 1.0
+	}
+	return h
+}
+
+// Returns the signed distance from hue a to hue b, going the shorter way
+// around the color wheel. The result is in [-0.5, 0.5].
+func hueDifference(a, b float64) float64 {
+	d := wrapHue(b - a)
+	if d > 0.5 {
+		d -= 1.0
+	}
+	return d
+}
+
+// Returns the fraction, from 0 to 1, by which an adjustment should apply at
+// (x, y), based on the brightness and alpha of the corresponding mask pixel.
+// The bounds must be the mask's canonical bounds. Pixels outside of the mask
+// aren't adjusted. Returns 1 if mask is nil.
+func maskWeight(mask image.Image, bounds image.Rectangle, x, y int) float64 {
+	if mask == nil {
+		return 1.0
+	}
+	p := image.Pt(bounds.Min.X+x, bounds.Min.Y+y)
+	if !p.In(bounds) {
+		return 0.0
+	}
+	// Since the color channels are alpha-premultiplied, the brightness is
+	// already scaled by the mask's alpha.
+	return clamp(convertToBrightness(mask.At(p.X, p.Y)))
+}
+
+// Calls adjust on the H, S and L components of each pixel, and blends the
+// result with the original pixel according to the mask. The mask may be nil,
+// in which case every pixel is fully adjusted. The mask is aligned with the
+// top-left corner of the image.
+func (h *HSLImage) adjustPixels(mask image.Image,
+	adjust func(hue, s, l float64) (float64, float64, float64)) {
+	var maskBounds image.Rectangle
+	if mask != nil {
+		maskBounds = mask.Bounds().Canon()
+	}
+	for y := 0; y < h.H; y++ {
+		for x := 0; x < h.W; x++ {
+			weight := maskWeight(mask, maskBounds, x, y)
+			if weight <= 0 {
+				continue
+			}
+			pixel := h.HSLPixel(x, y)
+			hue, s, l := pixel.HSLComponents()
+			newHue, newS, newL := adjust(hue, s, l)
+			hue = wrapHue(hue + hueDifference(hue, newHue)*weight)
+			s += (newS - s) * weight
+			l += (newL - l) * weight
+			pixel[0] = scaleTo16Bit(hue)
+			pixel[1] = scaleTo16Bit(s)
+			pixel[2] = scaleTo16Bit(l)
+		}
+	}
+}
+
+// Like AdjustHue, but limited by the given mask. The brightness of each mask
+// pixel determines how much the corresponding image pixel is affected; black
+// or transparent mask pixels leave the image unchanged. The mask's top-left
+// corner is aligned with the image's, and pixels outside of the mask aren't
+// changed. A nil mask adjusts every pixel. The other adjustments in this file
+// treat their mask arguments the same way.
+func (h *HSLImage) AdjustHueMasked(adjustment float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return wrapHue(hue + adjustment), s, l
+	})
+}
+
+// Multiplies the saturation of each pixel by the given factor.
+func (h *HSLImage) ScaleSaturation(factor float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return hue, clamp(s * factor), l
+	})
+}
+
+// Adds the given offset, which may be negative, to the saturation of each
+// pixel.
+func (h *HSLImage) OffsetSaturation(offset float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return hue, clamp(s + offset), l
+	})
+}
+
+// Multiplies the lightness of each pixel by the given factor.
+func (h *HSLImage) ScaleLightness(factor float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return hue, s, clamp(l * factor)
+	})
+}
+
+// Adds the given offset, which may be negative, to the lightness of each
+// pixel.
+func (h *HSLImage) OffsetLightness(offset float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return hue, s, clamp(l + offset)
+	})
+}
+
+// Increases the saturation of each pixel, with the increase weighted towards
+// less-saturated pixels so that already-vivid colors aren't oversaturated.
+// Each saturation s becomes s * (1 + amount * (1 - s)). The amount should be
+// in [-1, 1]; negative amounts reduce saturation.
+func (h *HSLImage) Vibrance(amount float64, mask image.Image) {
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		return hue, clamp(s * (1.0 + amount*(1.0-s))), l
+	})
+}
+
+// Sets the hue and saturation of each pixel to the given values, keeping its
+// lightness, which tints the image with a single color.
+func (h *HSLImage) Colorize(hue, saturation float64, mask image.Image) {
+	hue = wrapHue(hue)
+	saturation = clamp(saturation)
+	h.adjustPixels(mask, func(_, _, l float64) (float64, float64, float64) {
+		return hue, saturation, l
+	})
+}
+
+// Specifies a range of hues for SelectiveAdjust. All values are fractions of
+// the color wheel, where 0 is red, 1/3 is green and 2/3 is blue.
+type HueRange struct {
+	// The hue at the center of the range.
+	Center float64
+	// The total width of the range that is fully affected.
+	Width float64
+	// The width of the region beyond either side of the range, over which
+	// the adjustment fades out.
+	Feather float64
+}
+
+// Returns the fraction, from 0 to 1, by which the given hue falls within the
+// range.
+func (r HueRange) weight(hue float64) float64 {
+	d := math.Abs(hueDifference(r.Center, hue))
+	halfWidth := r.Width / 2.0
+	if d <= halfWidth {
+		return 1.0
+	}
+	if (r.Feather <= 0) || (d >= halfWidth+r.Feather) {
+		return 0.0
+	}
+	return 1.0 - (d-halfWidth)/r.Feather
+}
+
+// Adjusts only the pixels with hues in the given range. Matching pixels have
+// their hue shifted by hueShift, their saturation multiplied by
+// saturationScale, and lightnessOffset added to their lightness. For example,
+// passing HueRange{Center: 1.0 / 3.0, Width: 0.1, Feather: 0.05} affects only
+// greens. Gray pixels, which have no meaningful hue, are never affected.
+func (h *HSLImage) SelectiveAdjust(r HueRange, hueShift, saturationScale,
+	lightnessOffset float64, mask image.Image) error {
+	if (r.Width < 0) || (r.Feather < 0) {
+		return fmt.Errorf("The hue range's width and feather can't be " +
+			"negative")
+	}
+	h.adjustPixels(mask, func(hue, s, l float64) (float64, float64, float64) {
+		weight := r.weight(hue)
+		if (weight <= 0) || (s <= 0) {
+			return hue, s, l
+		}
+		newHue := wrapHue(hue + hueShift*weight)
+		newS := clamp(s + (s*saturationScale-s)*weight)
+		newL := clamp(l + lightnessOffset*weight)
+		return newHue, newS, newL
+	})
+	return nil
+}
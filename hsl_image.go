@@ -75,6 +75,69 @@ func (c HSLColor) RGBA() (r, g, b, a uint32) {
 	return
 }
 
+// Returns the R, G, and B components of the given color, each scaled to the
+// range [0, 1]. Ignores alpha.
+func colorToRGBFloats(c color.Color) (float64, float64, float64) {
+	r, g, b, _ := c.RGBA()
+	return float64(r) / float64(0xffff), float64(g) / float64(0xffff),
+		float64(b) / float64(0xffff)
+}
+
+// Returns the hue, in [0, 1), of the given RGB components, using the
+// hexagonal hue shared by HSL, HSV and HWB. The max and chroma must be the
+// largest component and the difference between the largest and smallest
+// components, respectively. Returns 0 for grays.
+func hexagonalHue(r, g, b, max, chroma float64) float64 {
+	if chroma == 0 {
+		return 0
+	}
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/chroma, 6.0)
+		if h < 0 {
+			h += 6.0
+		}
+	case g:
+		h = (b-r)/chroma + 2.0
+	default:
+		h = (r-g)/chroma + 4.0
+	}
+	return h / 6.0
+}
+
+// Returns the H, S, and L components of the given color, each scaled to the
+// range [0, 1]. Hue is 0 for grays. Ignores alpha.
+func rgbToHSLComponents(c color.Color) (float64, float64, float64) {
+	r, g, b := colorToRGBFloats(c)
+	maxValue := math.Max(r, math.Max(g, b))
+	minValue := math.Min(r, math.Min(g, b))
+	l := (maxValue + minValue) / 2.0
+	chroma := maxValue - minValue
+	if chroma == 0 {
+		return 0, 0, l
+	}
+	s := chroma / (1.0 - math.Abs(2.0*l-1.0))
+	return hexagonalHue(r, g, b, maxValue, chroma), clamp(s), l
+}
+
+// Converts an arbitrary color to HSL. Returns the original color if it's
+// already an HSLColor.
+func RGBToHSL(c color.Color) HSLColor {
+	hsl, ok := c.(HSLColor)
+	if ok {
+		return hsl
+	}
+	h, s, l := rgbToHSLComponents(c)
+	return HSLColor([]uint16{scaleTo16Bit(h), scaleTo16Bit(s),
+		scaleTo16Bit(l)})
+}
+
+// A color.Model that converts colors to HSLColor values.
+var HSLModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToHSL(c)
+})
+
 // Implements the image interface. Internally uses HSL representation for each
 // pixel.
 type HSLImage struct {
@@ -90,7 +153,7 @@ func (h *HSLImage) Bounds() image.Rectangle {
 }
 
 func (h *HSLImage) ColorModel() color.Model {
-	return color.RGBA64Model
+	return HSLModel
 }
 
 // Returns the HSLColor corresponding to the pixel at (x, y), or a separate,
@@ -107,6 +170,24 @@ func (h *HSLImage) At(x, y int) color.Color {
 	return h.HSLPixel(x, y)
 }
 
+// Sets the pixel at (x, y) to the given color, converted to HSL. Does nothing
+// if (x, y) is outside of the image.
+func (h *HSLImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= h.W) || (y >= h.H) {
+		return
+	}
+	i := 3 * (y*h.W + x)
+	hsl, ok := c.(HSLColor)
+	if ok {
+		copy(h.Pixels[i:i+3], hsl[:3])
+		return
+	}
+	hue, s, l := rgbToHSLComponents(c)
+	h.Pixels[i] = scaleTo16Bit(hue)
+	h.Pixels[i+1] = scaleTo16Bit(s)
+	h.Pixels[i+2] = scaleTo16Bit(l)
+}
+
 // Takes another image and sets a component of each of this image's pixels
 // based on the brightness of each pixel in pic. The "componentOffset" must be
 // 0 if setting hue, 1 if setting saturation, and 2 if setting luminosity.
@@ -155,5 +236,18 @@ func NewHSLImage(w, h int) (*HSLImage, error) {
 	}, nil
 }
 
-// TODO: Add a way to convert RGB to HSL color, and implement the Set(...)
-// function for HSLImage.
+// Returns a new HSLImage containing a copy of pic, converted to HSL. The new
+// image's top-left corner will be at (0, 0).
+func NewHSLImageFromImage(pic image.Image) (*HSLImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewHSLImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
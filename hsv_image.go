@@ -0,0 +1,197 @@
+package image_utils
+
+// This file contains an implementation of an HSV image, where each channel is
+// stored in a separate 16-bit plane. The color type follows HSLColor. The
+// planar storage helpers here are shared with HWBImage and HSIImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Implements the color interface. Stores the H, S, and V components,
+// respectively. *This will panic if the slice doesn't contain at least 3
+// components.* Values after the first 3 are ignored. Each component is a
+// fraction out of 0xffff.
+type HSVColor []uint16
+
+// Utility function to convert the 3 16-bit values to fractional components.
+func (c HSVColor) HSVComponents() (float64, float64, float64) {
+	h := float64(c[0]) / float64(0xffff)
+	s := float64(c[1]) / float64(0xffff)
+	v := float64(c[2]) / float64(0xffff)
+	return h, s, v
+}
+
+func (c HSVColor) String() string {
+	h, s, v := c.HSVComponents()
+	return fmt.Sprintf("(%f, %f, %f)", h, s, v)
+}
+
+func (c HSVColor) RGBA() (r, g, b, a uint32) {
+	h, s, v := c.HSVComponents()
+	r1, g1, b1 := hueToRGB(h)
+	chroma := v * s
+	r1 = v - (1.0-r1)*chroma
+	g1 = v - (1.0-g1)*chroma
+	b1 = v - (1.0-b1)*chroma
+	r = uint32(scaleTo16Bit(r1))
+	g = uint32(scaleTo16Bit(g1))
+	b = uint32(scaleTo16Bit(b1))
+	a = 0xffff
+	return
+}
+
+// Returns the H, S, and V components of the given color, each scaled to the
+// range [0, 1]. Hue is 0 for grays. Ignores alpha.
+func rgbToHSVComponents(c color.Color) (float64, float64, float64) {
+	r, g, b := colorToRGBFloats(c)
+	maxValue := math.Max(r, math.Max(g, b))
+	minValue := math.Min(r, math.Min(g, b))
+	chroma := maxValue - minValue
+	if chroma == 0 {
+		return 0, 0, maxValue
+	}
+	s := chroma / maxValue
+	return hexagonalHue(r, g, b, maxValue, chroma), clamp(s), maxValue
+}
+
+// Converts an arbitrary color to HSV. Returns the original color if it's
+// already an HSVColor.
+func RGBToHSV(c color.Color) HSVColor {
+	hsv, ok := c.(HSVColor)
+	if ok {
+		return hsv
+	}
+	h, s, v := rgbToHSVComponents(c)
+	return HSVColor([]uint16{scaleTo16Bit(h), scaleTo16Bit(s),
+		scaleTo16Bit(v)})
+}
+
+// A color.Model that converts colors to HSVColor values.
+var HSVModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToHSV(c)
+})
+
+// Returns a copy of the 3 components of the pixel at (x, y) in a w x h image
+// that stores each component in a separate plane, like HSVImage. Returns a
+// copy of outside if the coordinate is outside of the image boundaries.
+func planarPixel(planes [3][]uint16, w, h, x, y int,
+	outside [3]uint16) []uint16 {
+	if (x < 0) || (y < 0) || (x >= w) || (y >= h) {
+		return outside[:]
+	}
+	i := y*w + x
+	return []uint16{planes[0][i], planes[1][i], planes[2][i]}
+}
+
+// Sets one of the planes of a w x h image, like HSVImage.SetComponent, from
+// the brightness of each pixel in pic. The componentOffset must be 0, 1 or 2.
+// Pixels in pic that fall outside of the image are ignored.
+func setPlanarComponent(planes [3][]uint16, w, h int, pic image.Image,
+	componentOffset int) error {
+	if (componentOffset < 0) || (componentOffset > 2) {
+		return fmt.Errorf("Invalid component offset: %d", componentOffset)
+	}
+	plane := planes[componentOffset]
+	bounds := pic.Bounds().Canon()
+	for localY := 0; (localY < bounds.Dy()) && (localY < h); localY++ {
+		for localX := 0; (localX < bounds.Dx()) && (localX < w); localX++ {
+			// Convert the new component from the grayscale brightness of the
+			// pixel in the source pic.
+			c := pic.At(bounds.Min.X+localX, bounds.Min.Y+localY)
+			plane[localY*w+localX] = scaleTo16Bit(convertToBrightness(c))
+		}
+	}
+	return nil
+}
+
+// Implements the image interface. Internally uses HSV representation for each
+// pixel, with each component stored in a separate plane.
+type HSVImage struct {
+	// Each pixel's components, with one plane per component, in row-major
+	// order. Each plane must contain W * H values.
+	Hue, Saturation, Value []uint16
+	W, H                   int
+}
+
+// Returns the image's planes, in the order used by SetComponent.
+func (h *HSVImage) planes() [3][]uint16 {
+	return [3][]uint16{h.Hue, h.Saturation, h.Value}
+}
+
+func (h *HSVImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, h.W, h.H)
+}
+
+func (h *HSVImage) ColorModel() color.Model {
+	return HSVModel
+}
+
+// Returns a copy of the HSVColor at (x, y), or a separate, black, HSVColor
+// if the coordinate is outside of the image boundaries.
+func (h *HSVImage) HSVPixel(x, y int) HSVColor {
+	return HSVColor(planarPixel(h.planes(), h.W, h.H, x, y, [3]uint16{}))
+}
+
+func (h *HSVImage) At(x, y int) color.Color {
+	return h.HSVPixel(x, y)
+}
+
+// Sets the pixel at (x, y) to the given color, converted to HSV. Does nothing
+// if (x, y) is outside of the image.
+func (h *HSVImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= h.W) || (y >= h.H) {
+		return
+	}
+	i := y*h.W + x
+	hsv, ok := c.(HSVColor)
+	if ok {
+		h.Hue[i] = hsv[0]
+		h.Saturation[i] = hsv[1]
+		h.Value[i] = hsv[2]
+		return
+	}
+	hue, s, v := rgbToHSVComponents(c)
+	h.Hue[i] = scaleTo16Bit(hue)
+	h.Saturation[i] = scaleTo16Bit(s)
+	h.Value[i] = scaleTo16Bit(v)
+}
+
+// Takes another image and sets a component of each of this image's pixels
+// based on the brightness of each pixel in pic. The "componentOffset" must be
+// 0 if setting hue, 1 if setting saturation, and 2 if setting value.
+func (h *HSVImage) SetComponent(pic image.Image, componentOffset int) error {
+	return setPlanarComponent(h.planes(), h.W, h.H, pic, componentOffset)
+}
+
+func NewHSVImage(w, h int) (*HSVImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	return &HSVImage{
+		W:          w,
+		H:          h,
+		Hue:        make([]uint16, w*h),
+		Saturation: make([]uint16, w*h),
+		Value:      make([]uint16, w*h),
+	}, nil
+}
+
+// Returns a new HSVImage containing a copy of pic, converted to HSV. The new
+// image's top-left corner will be at (0, 0).
+func NewHSVImageFromImage(pic image.Image) (*HSVImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewHSVImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,173 @@
+package image_utils
+
+// This file contains an implementation of an HWB (hue, whiteness, blackness)
+// image, where each channel is stored in a separate 16-bit plane. It's
+// organized the same way as HSVImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Implements the color interface. Stores the H, W, and B components,
+// respectively. *This will panic if the slice doesn't contain at least 3
+// components.* Values after the first 3 are ignored. Each component is a
+// fraction out of 0xffff.
+type HWBColor []uint16
+
+// Utility function to convert the 3 16-bit values to fractional components.
+func (c HWBColor) HWBComponents() (float64, float64, float64) {
+	h := float64(c[0]) / float64(0xffff)
+	w := float64(c[1]) / float64(0xffff)
+	b := float64(c[2]) / float64(0xffff)
+	return h, w, b
+}
+
+func (c HWBColor) String() string {
+	h, w, b := c.HWBComponents()
+	return fmt.Sprintf("(%f, %f, %f)", h, w, b)
+}
+
+func (c HWBColor) RGBA() (r, g, b, a uint32) {
+	h, whiteness, blackness := c.HWBComponents()
+	// If the whiteness and blackness add up to more than 1, they're scaled
+	// down proportionally, producing a gray.
+	total := whiteness + blackness
+	if total >= 1.0 {
+		gray := uint32(scaleTo16Bit(whiteness / total))
+		return gray, gray, gray, 0xffff
+	}
+	r1, g1, b1 := hueToRGB(h)
+	scale := 1.0 - total
+	r = uint32(scaleTo16Bit(r1*scale + whiteness))
+	g = uint32(scaleTo16Bit(g1*scale + whiteness))
+	b = uint32(scaleTo16Bit(b1*scale + whiteness))
+	a = 0xffff
+	return
+}
+
+// Returns the H, W, and B components of the given color, each scaled to the
+// range [0, 1]. Hue is 0 for grays. Ignores alpha.
+func rgbToHWBComponents(c color.Color) (float64, float64, float64) {
+	r, g, b := colorToRGBFloats(c)
+	maxValue := math.Max(r, math.Max(g, b))
+	minValue := math.Min(r, math.Min(g, b))
+	h := hexagonalHue(r, g, b, maxValue, maxValue-minValue)
+	return h, minValue, 1.0 - maxValue
+}
+
+// Converts an arbitrary color to HWB. Returns the original color if it's
+// already an HWBColor.
+func RGBToHWB(c color.Color) HWBColor {
+	hwb, ok := c.(HWBColor)
+	if ok {
+		return hwb
+	}
+	h, w, b := rgbToHWBComponents(c)
+	return HWBColor([]uint16{scaleTo16Bit(h), scaleTo16Bit(w),
+		scaleTo16Bit(b)})
+}
+
+// A color.Model that converts colors to HWBColor values.
+var HWBModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	return RGBToHWB(c)
+})
+
+// Implements the image interface. Internally uses HWB representation for each
+// pixel, with each component stored in a separate plane.
+type HWBImage struct {
+	// Each pixel's components, with one plane per component, in row-major
+	// order. Each plane must contain W * H values.
+	Hue, Whiteness, Blackness []uint16
+	W, H                      int
+}
+
+// Returns the image's planes, in the order used by SetComponent.
+func (h *HWBImage) planes() [3][]uint16 {
+	return [3][]uint16{h.Hue, h.Whiteness, h.Blackness}
+}
+
+func (h *HWBImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, h.W, h.H)
+}
+
+func (h *HWBImage) ColorModel() color.Model {
+	return HWBModel
+}
+
+// Returns a copy of the HWBColor at (x, y), or a separate, black, HWBColor
+// if the coordinate is outside of the image boundaries.
+func (h *HWBImage) HWBPixel(x, y int) HWBColor {
+	// Unlike the other hue-based colors, all-zero components are red, so
+	// black requires a blackness of 1.
+	black := [3]uint16{0, 0, 0xffff}
+	return HWBColor(planarPixel(h.planes(), h.W, h.H, x, y, black))
+}
+
+func (h *HWBImage) At(x, y int) color.Color {
+	return h.HWBPixel(x, y)
+}
+
+// Sets the pixel at (x, y) to the given color, converted to HWB. Does nothing
+// if (x, y) is outside of the image.
+func (h *HWBImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= h.W) || (y >= h.H) {
+		return
+	}
+	i := y*h.W + x
+	hwb, ok := c.(HWBColor)
+	if ok {
+		h.Hue[i] = hwb[0]
+		h.Whiteness[i] = hwb[1]
+		h.Blackness[i] = hwb[2]
+		return
+	}
+	hue, w, b := rgbToHWBComponents(c)
+	h.Hue[i] = scaleTo16Bit(hue)
+	h.Whiteness[i] = scaleTo16Bit(w)
+	h.Blackness[i] = scaleTo16Bit(b)
+}
+
+// Takes another image and sets a component of each of this image's pixels
+// based on the brightness of each pixel in pic. The "componentOffset" must be
+// 0 if setting hue, 1 if setting whiteness, and 2 if setting blackness.
+func (h *HWBImage) SetComponent(pic image.Image, componentOffset int) error {
+	return setPlanarComponent(h.planes(), h.W, h.H, pic, componentOffset)
+}
+
+// Returns a new HWBImage with every pixel set to black, like the other
+// hue-based images.
+func NewHWBImage(w, h int) (*HWBImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	blackness := make([]uint16, w*h)
+	for i := range blackness {
+		blackness[i] = 0xffff
+	}
+	return &HWBImage{
+		W:         w,
+		H:         h,
+		Hue:       make([]uint16, w*h),
+		Whiteness: make([]uint16, w*h),
+		Blackness: blackness,
+	}, nil
+}
+
+// Returns a new HWBImage containing a copy of pic, converted to HWB. The new
+// image's top-left corner will be at (0, 0).
+func NewHWBImageFromImage(pic image.Image) (*HWBImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewHWBImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
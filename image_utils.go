@@ -82,6 +82,7 @@ func (r *ResizedImage) At(x, y int) color.Color {
 }
 
 // Returns true if the two colors are exactly equal in the RGBA color space.
+// See ColorsSimilar for a tolerance-based comparison.
 func ColorsEqual(a, b color.Color) bool {
 	r1, g1, b1, a1 := a.RGBA()
 	r2, g2, b2, a2 := b.RGBA()
@@ -89,7 +90,8 @@ func ColorsEqual(a, b color.Color) bool {
 }
 
 // Implements the color interface, but uses floating-point colors for easier
-// multiplication. Does not include alpha for now.
+// multiplication. Does not include alpha for now. The components are
+// gamma-encoded sRGB; see LinearColor for an alpha-aware, linear-light color.
 type FloatColor struct {
 	R float32
 	G float32
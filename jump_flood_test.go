@@ -0,0 +1,108 @@
+package image_utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// Compares the seed assigned to each pixel against the closest seed found by
+// a brute-force search over every seed, using the same distance metric and
+// weighting. Returns the fraction of pixels, from 0 to 1, that were assigned
+// to a seed farther than the closest one. Returns an error if the image
+// contains no seeds.
+func bruteForceErrorRate(v *VoronoiImage) (float64, error) {
+	seeds := make([]*VoronoiPixel, 0, 64)
+	for i := range v.Pixels {
+		if v.Pixels[i].IsSeed {
+			seeds = append(seeds, &(v.Pixels[i]))
+		}
+	}
+	if len(seeds) == 0 {
+		return 0, fmt.Errorf("The image doesn't contain any seeds")
+	}
+	errors := 0
+	for y := 0; y < v.H; y++ {
+		for x := 0; x < v.W; x++ {
+			p := v.At(x, y)
+			if !p.IsDefined {
+				errors++
+				continue
+			}
+			current := v.seedDistance(x, y, p)
+			for _, seed := range seeds {
+				if v.seedDistance(x, y, seed) < current {
+					errors++
+					break
+				}
+			}
+		}
+	}
+	return float64(errors) / float64(v.W*v.H), nil
+}
+
+// Returns a w x h VoronoiImage with the given number of randomly placed
+// seeds, each with a random color.
+func randomVoronoiImage(r *rand.Rand, w, h, seeds int) (*VoronoiImage,
+	error) {
+	toReturn, e := NewVoronoiImage(w, h)
+	if e != nil {
+		return nil, e
+	}
+	for i := 0; i < seeds; i++ {
+		p := image.Pt(r.Intn(w), r.Intn(h))
+		c := color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)),
+			uint8(r.Intn(256)), 0xff}
+		e = toReturn.AddSeed(p, c)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return toReturn, nil
+}
+
+func TestJumpFloodVariants(t *testing.T) {
+	variants := []JumpFloodVariant{
+		StandardJumpFlood,
+		JumpFloodPlusOne,
+		JumpFloodPlusTwo,
+		OnePlusJumpFlood,
+	}
+	errorRates := make(map[JumpFloodVariant]float64)
+	for _, variant := range variants {
+		// Use the same seeds for every variant so their error rates are
+		// comparable.
+		r := rand.New(rand.NewSource(1337))
+		total := 0.0
+		imageCount := 8
+		for i := 0; i < imageCount; i++ {
+			v, e := randomVoronoiImage(r, 128, 128, 200)
+			if e != nil {
+				t.Fatalf("Failed creating Voronoi image: %s", e)
+			}
+			v.Options = &VoronoiOptions{
+				Variant: variant,
+			}
+			e = v.JumpFloodFill()
+			if e != nil {
+				t.Fatalf("Failed running %s fill: %s", variant, e)
+			}
+			rate, e := bruteForceErrorRate(v)
+			if e != nil {
+				t.Fatalf("Failed checking %s fill: %s", variant, e)
+			}
+			total += rate
+		}
+		errorRates[variant] = total / float64(imageCount)
+		t.Logf("%s error rate: %.4f%%", variant, errorRates[variant]*100.0)
+	}
+	standard := errorRates[StandardJumpFlood]
+	for _, variant := range variants[1:] {
+		if errorRates[variant] > standard {
+			t.Errorf("%s error rate (%f) is worse than %s (%f)", variant,
+				errorRates[variant], StandardJumpFlood, standard)
+		}
+	}
+}
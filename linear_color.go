@@ -0,0 +1,239 @@
+package image_utils
+
+// This file contains a floating-point color type that stores linear-light,
+// alpha-premultiplied components, which is what blurring and blending should
+// operate on. FloatColor, in contrast, holds gamma-encoded sRGB components and
+// no alpha.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Implements the color interface. Holds linear-light red, green and blue
+// components, premultiplied by the alpha component. Unlike FloatColor, sums
+// and scalings of LinearColors correspond to physical mixing of light, and
+// transparent pixels don't contribute to the color of their neighbors. Each
+// component of a visible color is normally in [0, 1], and R, G and B shouldn't
+// exceed A.
+type LinearColor struct {
+	R, G, B, A float32
+}
+
+// Returns a LinearColor from linear-light components that are not
+// premultiplied.
+func Premultiply(r, g, b, a float32) LinearColor {
+	return LinearColor{
+		R: r * a,
+		G: g * a,
+		B: b * a,
+		A: a,
+	}
+}
+
+// Returns the color's linear-light components, no longer premultiplied by
+// alpha. Returns all zeros if the color is fully transparent.
+func (c LinearColor) Unpremultiply() (r, g, b, a float32) {
+	if c.A <= 0 {
+		return 0, 0, 0, 0
+	}
+	return c.R / c.A, c.G / c.A, c.B / c.A, c.A
+}
+
+// Converts a gamma-encoded sRGB color with the given alpha to a LinearColor.
+func DecodeSRGB(c FloatColor, alpha float32) LinearColor {
+	return Premultiply(float32(SRGBToLinear(float64(clamp32(c.R)))),
+		float32(SRGBToLinear(float64(clamp32(c.G)))),
+		float32(SRGBToLinear(float64(clamp32(c.B)))), clamp32(alpha))
+}
+
+// Converts the color to gamma-encoded sRGB, returning the color, which isn't
+// premultiplied, and its alpha separately. This is the inverse of DecodeSRGB.
+func (c LinearColor) EncodeSRGB() (FloatColor, float32) {
+	r, g, b, a := c.Unpremultiply()
+	return FloatColor{
+		R: float32(LinearToSRGB(float64(clamp32(r)))),
+		G: float32(LinearToSRGB(float64(clamp32(g)))),
+		B: float32(LinearToSRGB(float64(clamp32(b)))),
+	}, clamp32(a)
+}
+
+func (c LinearColor) Add(toAdd color.Color) LinearColor {
+	converted := ConvertToLinearColor(toAdd)
+	return LinearColor{
+		R: c.R + converted.R,
+		G: c.G + converted.G,
+		B: c.B + converted.B,
+		A: c.A + converted.A,
+	}
+}
+
+func (c LinearColor) Scale(scale float32) LinearColor {
+	return LinearColor{
+		R: c.R * scale,
+		G: c.G * scale,
+		B: c.B * scale,
+		A: c.A * scale,
+	}
+}
+
+// Returns the result of compositing c on top of the given background, using
+// the Porter-Duff "over" operator.
+func (c LinearColor) Over(background color.Color) LinearColor {
+	return c.Add(ConvertToLinearColor(background).Scale(1.0 - clamp32(c.A)))
+}
+
+func (c LinearColor) RGBA() (r, g, b, a uint32) {
+	encoded, alpha := c.EncodeSRGB()
+	// The color interface requires alpha-premultiplied components, which
+	// must be premultiplied after encoding.
+	a = uint32(alpha * float32(0xffff))
+	r = uint32(encoded.R * float32(a))
+	g = uint32(encoded.G * float32(a))
+	b = uint32(encoded.B * float32(a))
+	return
+}
+
+func (c LinearColor) String() string {
+	return fmt.Sprintf("(%f, %f, %f, %f)", c.R, c.G, c.B, c.A)
+}
+
+// Takes an arbitrary color and returns a LinearColor, preserving its alpha.
+// Returns the original color if it's already a LinearColor.
+func ConvertToLinearColor(c color.Color) LinearColor {
+	tryResult, ok := c.(LinearColor)
+	if ok {
+		return tryResult
+	}
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return LinearColor{}
+	}
+	// Undo the premultiplication before decoding, since the sRGB transfer
+	// function applies to the straight components.
+	alpha := float32(a) / 0xffff
+	return DecodeSRGB(FloatColor{
+		R: float32(r) / float32(a),
+		G: float32(g) / float32(a),
+		B: float32(b) / float32(a),
+	}, alpha)
+}
+
+// A color.Model that converts colors to LinearColor values.
+var LinearColorModel color.Model = color.ModelFunc(
+	func(c color.Color) color.Color {
+		return ConvertToLinearColor(c)
+	})
+
+// This implements the image.Image interface using LinearColor pixels. Pixels
+// are initially fully transparent.
+type LinearColorImage struct {
+	W, H   int
+	Pixels []LinearColor
+}
+
+func (l *LinearColorImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, l.W, l.H)
+}
+
+func (l *LinearColorImage) ColorModel() color.Model {
+	return LinearColorModel
+}
+
+func (l *LinearColorImage) At(x, y int) color.Color {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return LinearColor{}
+	}
+	return l.Pixels[y*l.W+x]
+}
+
+// Adds a color to the given location in the LinearColorImage.
+func (l *LinearColorImage) Add(x, y int, toAdd color.Color) {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return
+	}
+	l.Pixels[y*l.W+x] = l.Pixels[y*l.W+x].Add(toAdd)
+}
+
+func (l *LinearColorImage) Set(x, y int, c color.Color) {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return
+	}
+	l.Pixels[y*l.W+x] = ConvertToLinearColor(c)
+}
+
+// Creates a new, fully transparent, LinearColorImage with the given
+// dimensions.
+func NewLinearColorImage(w, h int) (*LinearColorImage, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	return &LinearColorImage{
+		W:      w,
+		H:      h,
+		Pixels: make([]LinearColor, w*h),
+	}, nil
+}
+
+// Returns a new LinearColorImage containing a copy of pic. The new image's
+// top-left corner will be at (0, 0).
+func NewLinearColorImageFromImage(pic image.Image) (*LinearColorImage,
+	error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewLinearColorImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < toReturn.H; y++ {
+		for x := 0; x < toReturn.W; x++ {
+			toReturn.Set(x, y, pic.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return toReturn, nil
+}
+
+// Like BlurredFloatColorImage, but wraps a LinearColorImage. Since the pixels
+// are linear and premultiplied, the blur neither darkens edges between bright
+// colors nor bleeds the color of transparent pixels into their neighbors.
+type BlurredLinearColorImage struct {
+	Pic    *LinearColorImage
+	Radius int
+}
+
+func (m *BlurredLinearColorImage) Bounds() image.Rectangle {
+	return m.Pic.Bounds()
+}
+
+func (m *BlurredLinearColorImage) ColorModel() color.Model {
+	return m.Pic.ColorModel()
+}
+
+func (m *BlurredLinearColorImage) At(x, y int) color.Color {
+	rSquared := float32(m.Radius) * float32(m.Radius)
+	var sum LinearColor
+	pixels := 0
+	for j := y - m.Radius; j < y+m.Radius+1; j++ {
+		if (j < 0) || (j >= m.Pic.H) {
+			continue
+		}
+		dy := float32(y - j)
+		dy2 := dy * dy
+		for i := x - m.Radius; i < x+m.Radius+1; i++ {
+			if (i < 0) || (i >= m.Pic.W) {
+				continue
+			}
+			dx := float32(x - i)
+			distanceSquared := dx*dx + dy2
+			if distanceSquared > rSquared {
+				continue
+			}
+			pixels++
+			sum = sum.Add(m.Pic.Pixels[j*m.Pic.W+i])
+		}
+	}
+	if pixels == 0 {
+		return LinearColor{}
+	}
+	return sum.Scale(1.0 / float32(pixels))
+}
@@ -0,0 +1,267 @@
+package image_utils
+
+// This file contains morphological operations, such as erosion and dilation,
+// that can be applied to any DrawableImage.
+
+import (
+	"fmt"
+	"image"
+)
+
+// Defines the neighborhood used by morphological operations. Each offset is
+// relative to the pixel being processed, so an offset of (0, 0) refers to the
+// pixel itself.
+type StructuringElement struct {
+	Offsets []image.Point
+}
+
+// Returns a StructuringElement containing each point produced by the given
+// ShapeWalker, used directly as offsets. For example, a walker returned by
+// GetDiskWalker(image.Pt(0, 0), 3) produces a disk-shaped element centered on
+// each pixel. Duplicate points are only included once.
+func NewStructuringElement(w ShapeWalker) (*StructuringElement, error) {
+	// Arbitrarily limit structuring elements to a sane number of steps, as
+	// DrawLine does.
+	maxSteps := 200000000
+	seen := make(map[image.Point]bool)
+	offsets := make([]image.Point, 0, 64)
+	w.Reset()
+	for step := 0; !w.Done(); step++ {
+		if step >= maxSteps {
+			return nil, fmt.Errorf("The structuring element's shape is " +
+				"too large")
+		}
+		p := w.Next()
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		offsets = append(offsets, p)
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("The structuring element contains no points")
+	}
+	return &StructuringElement{
+		Offsets: offsets,
+	}, nil
+}
+
+// Returns a StructuringElement containing each pixel in the mask that is at
+// least half-way bright and not fully transparent. The offsets are relative to
+// the given origin, which is in the mask's coordinates.
+func NewStructuringElementFromMask(mask image.Image,
+	origin image.Point) (*StructuringElement, error) {
+	bounds := mask.Bounds().Canon()
+	offsets := make([]image.Point, 0, 64)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := mask.At(x, y)
+			_, _, _, a := c.RGBA()
+			if (a == 0) || (convertToBrightness(c) < 0.5) {
+				continue
+			}
+			offsets = append(offsets, image.Pt(x-origin.X, y-origin.Y))
+		}
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("The mask doesn't contain any set pixels")
+	}
+	return &StructuringElement{
+		Offsets: offsets,
+	}, nil
+}
+
+// Returns a disk-shaped StructuringElement with the given radius, centered on
+// (0, 0).
+func DiskStructuringElement(radius int) (*StructuringElement, error) {
+	if radius < 0 {
+		return nil, fmt.Errorf("The radius can't be negative, got %d", radius)
+	}
+	return NewStructuringElement(GetDiskWalker(image.Pt(0, 0), radius))
+}
+
+// Returns a rectangular StructuringElement with the given width and height,
+// centered on (0, 0). If a dimension is even, the extra row or column is on
+// the positive side.
+func RectangleStructuringElement(w, h int) (*StructuringElement, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("The element's dimensions must be positive, "+
+			"got %dx%d", w, h)
+	}
+	minX := -((w - 1) / 2)
+	minY := -((h - 1) / 2)
+	r := image.Rect(minX, minY, minX+w, minY+h)
+	return NewStructuringElement(GetFilledRectangleWalker(r))
+}
+
+// Computes the erosion (if dilate is false) or dilation (if dilate is true) of
+// each channel in the planes, returning the result as new planes. Offsets that
+// fall outside of the image are ignored.
+func morphPlanes(src *channelPlanes, se *StructuringElement,
+	dilate bool) *channelPlanes {
+	w := src.w
+	h := src.h
+	toReturn := &channelPlanes{
+		w:      w,
+		h:      h,
+		bounds: src.bounds,
+	}
+	for c := range toReturn.c {
+		toReturn.c[c] = make([]float32, w*h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var result [4]float32
+			found := false
+			for _, offset := range se.Offsets {
+				// Dilation uses the reflected element, so that opening and
+				// closing behave correctly for asymmetric elements.
+				sx := x + offset.X
+				sy := y + offset.Y
+				if dilate {
+					sx = x - offset.X
+					sy = y - offset.Y
+				}
+				if (sx < 0) || (sy < 0) || (sx >= w) || (sy >= h) {
+					continue
+				}
+				i := sy*w + sx
+				for c := range result {
+					v := src.c[c][i]
+					if !found {
+						result[c] = v
+					} else if dilate && (v > result[c]) {
+						result[c] = v
+					} else if !dilate && (v < result[c]) {
+						result[c] = v
+					}
+				}
+				found = true
+			}
+			if !found {
+				// None of the element overlapped the image, so leave the
+				// pixel unchanged.
+				for c := range result {
+					result[c] = src.c[c][y*w+x]
+				}
+			}
+			for c := range result {
+				toReturn.c[c][y*w+x] = result[c]
+			}
+		}
+	}
+	return toReturn
+}
+
+// Sets each color channel in a to a - b. The resulting alpha channel is the
+// larger of the two alphas.
+func subtractPlanes(a, b *channelPlanes) {
+	for i := range a.c[3] {
+		for c := 0; c < 3; c++ {
+			a.c[c][i] = clamp32(a.c[c][i] - b.c[c][i])
+		}
+		if b.c[3][i] > a.c[3][i] {
+			a.c[3][i] = b.c[3][i]
+		}
+	}
+}
+
+// Runs the given sequence of erosions and dilations on pic, overwriting it
+// with the result. The steps are applied in order; true means to dilate.
+func morphImage(pic DrawableImage, se *StructuringElement,
+	steps ...bool) (*channelPlanes, *channelPlanes, error) {
+	if (se == nil) || (len(se.Offsets) == 0) {
+		return nil, nil, fmt.Errorf("An empty structuring element was given")
+	}
+	original, e := newChannelPlanes(pic)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Error reading image pixels: %w", e)
+	}
+	result := original
+	for _, dilate := range steps {
+		result = morphPlanes(result, se, dilate)
+	}
+	return original, result, nil
+}
+
+// Erodes each channel in pic using the given structuring element. Works on
+// both grayscale and color images; binary images (containing only black and
+// white pixels) remain binary.
+func Erode(pic DrawableImage, se *StructuringElement) error {
+	_, result, e := morphImage(pic, se, false)
+	if e != nil {
+		return e
+	}
+	result.writeTo(pic)
+	return nil
+}
+
+// Dilates each channel in pic using the given structuring element.
+func Dilate(pic DrawableImage, se *StructuringElement) error {
+	_, result, e := morphImage(pic, se, true)
+	if e != nil {
+		return e
+	}
+	result.writeTo(pic)
+	return nil
+}
+
+// Applies a morphological opening (an erosion followed by a dilation) to pic.
+// Useful for removing small bright specks from masks.
+func Open(pic DrawableImage, se *StructuringElement) error {
+	_, result, e := morphImage(pic, se, false, true)
+	if e != nil {
+		return e
+	}
+	result.writeTo(pic)
+	return nil
+}
+
+// Applies a morphological closing (a dilation followed by an erosion) to pic.
+// Useful for filling small dark holes in masks.
+func Close(pic DrawableImage, se *StructuringElement) error {
+	_, result, e := morphImage(pic, se, true, false)
+	if e != nil {
+		return e
+	}
+	result.writeTo(pic)
+	return nil
+}
+
+// Replaces pic with its morphological gradient: the difference between its
+// dilation and its erosion. This highlights the edges of shapes.
+func MorphologicalGradient(pic DrawableImage, se *StructuringElement) error {
+	original, dilated, e := morphImage(pic, se, true)
+	if e != nil {
+		return e
+	}
+	eroded := morphPlanes(original, se, false)
+	subtractPlanes(dilated, eroded)
+	dilated.writeTo(pic)
+	return nil
+}
+
+// Replaces pic with its white top-hat transform: the difference between the
+// image and its opening. This keeps bright details smaller than the element.
+func TopHat(pic DrawableImage, se *StructuringElement) error {
+	original, opened, e := morphImage(pic, se, false, true)
+	if e != nil {
+		return e
+	}
+	subtractPlanes(original, opened)
+	original.writeTo(pic)
+	return nil
+}
+
+// Replaces pic with its black top-hat transform: the difference between the
+// image's closing and the image. This keeps dark details smaller than the
+// element.
+func BlackHat(pic DrawableImage, se *StructuringElement) error {
+	original, closed, e := morphImage(pic, se, true, false)
+	if e != nil {
+		return e
+	}
+	subtractPlanes(closed, original)
+	closed.writeTo(pic)
+	return nil
+}
@@ -0,0 +1,383 @@
+package image_utils
+
+// This file contains procedural noise generators, such as Perlin and
+// OpenSimplex2 noise, along with an image type that lazily combines octaves
+// of noise.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// A source of two-dimensional noise. Noise should return a value roughly in
+// the range [-1, 1], which varies smoothly with x and y and repeats its
+// features about once per unit.
+type NoiseSource interface {
+	Noise(x, y float64) float64
+}
+
+// Returns the "smootherstep" curve, 6t^5 - 15t^4 + 10t^3, used to interpolate
+// between lattice points.
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6.0-15.0) + 10.0)
+}
+
+// Linearly interpolates from a to b.
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// Implements the NoiseSource interface using value noise, which smoothly
+// interpolates random values at each integer lattice point.
+type valueNoise struct {
+	seed int64
+}
+
+// Returns a NoiseSource producing value noise, determined by the given seed.
+func NewValueNoise(seed int64) NoiseSource {
+	return &valueNoise{
+		seed: seed,
+	}
+}
+
+// Returns the random value at the given lattice point, in [-1, 1].
+func (n *valueNoise) latticeValue(x, y int) float64 {
+	return hashToFloat(latticeHash(n.seed, x, y))*2.0 - 1.0
+}
+
+func (n *valueNoise) Noise(x, y float64) float64 {
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	ix := int(x0)
+	iy := int(y0)
+	tx := fade(x - x0)
+	ty := fade(y - y0)
+	top := lerp(n.latticeValue(ix, iy), n.latticeValue(ix+1, iy), tx)
+	bottom := lerp(n.latticeValue(ix, iy+1), n.latticeValue(ix+1, iy+1), tx)
+	return lerp(top, bottom, ty)
+}
+
+// Implements the NoiseSource interface using Ken Perlin's gradient noise.
+type perlinNoise struct {
+	seed int64
+}
+
+// Returns a NoiseSource producing Perlin (gradient) noise, determined by the
+// given seed.
+func NewPerlinNoise(seed int64) NoiseSource {
+	return &perlinNoise{
+		seed: seed,
+	}
+}
+
+// The gradients used by Perlin noise: eight evenly-spaced unit vectors.
+var perlinGradients = [8][2]float64{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{math.Sqrt2 / 2, math.Sqrt2 / 2}, {-math.Sqrt2 / 2, math.Sqrt2 / 2},
+	{math.Sqrt2 / 2, -math.Sqrt2 / 2}, {-math.Sqrt2 / 2, -math.Sqrt2 / 2},
+}
+
+// Returns the dot product of the gradient at lattice point (ix, iy) with the
+// offset (dx, dy).
+func (n *perlinNoise) gradientDot(ix, iy int, dx, dy float64) float64 {
+	g := perlinGradients[latticeHash(n.seed, ix, iy)&7]
+	return g[0]*dx + g[1]*dy
+}
+
+func (n *perlinNoise) Noise(x, y float64) float64 {
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	ix := int(x0)
+	iy := int(y0)
+	dx := x - x0
+	dy := y - y0
+	tx := fade(dx)
+	ty := fade(dy)
+	top := lerp(n.gradientDot(ix, iy, dx, dy),
+		n.gradientDot(ix+1, iy, dx-1, dy), tx)
+	bottom := lerp(n.gradientDot(ix, iy+1, dx, dy-1),
+		n.gradientDot(ix+1, iy+1, dx-1, dy-1), tx)
+	// With unit gradients, 2D Perlin noise is within [-sqrt(0.5), sqrt(0.5)].
+	return lerp(top, bottom, ty) * math.Sqrt2
+}
+
+// Constants used by the OpenSimplex2 noise implementation, taken from the
+// public domain reference implementation at
+// https://github.com/KdotJPG/OpenSimplex2
+const (
+	openSimplexPrimeX     = 0x5205402B9270C86F
+	openSimplexPrimeY     = 0x598CD327003817B5
+	openSimplexHashMult   = 0x53A3F72DEEC546F5
+	openSimplexSkew       = 0.366025403784439
+	openSimplexUnskew     = -0.21132486540518713
+	openSimplexRSquared   = 0.5
+	openSimplexNormalizer = 0.01001634121365712
+	openSimplexGradExp    = 7
+)
+
+// The unnormalized 2D gradients used by OpenSimplex2, as (x, y) pairs.
+var openSimplexBaseGradients = []float64{
+	0.38268343236509, 0.923879532511287,
+	0.923879532511287, 0.38268343236509,
+	0.923879532511287, -0.38268343236509,
+	0.38268343236509, -0.923879532511287,
+	-0.38268343236509, -0.923879532511287,
+	-0.923879532511287, -0.38268343236509,
+	-0.923879532511287, 0.38268343236509,
+	-0.38268343236509, 0.923879532511287,
+	0.130526192220052, 0.99144486137381,
+	0.608761429008721, 0.793353340291235,
+	0.793353340291235, 0.608761429008721,
+	0.99144486137381, 0.130526192220051,
+	0.99144486137381, -0.130526192220051,
+	0.793353340291235, -0.60876142900872,
+	0.608761429008721, -0.793353340291235,
+	0.130526192220052, -0.99144486137381,
+	-0.130526192220052, -0.99144486137381,
+	-0.608761429008721, -0.793353340291235,
+	-0.793353340291235, -0.608761429008721,
+	-0.99144486137381, -0.130526192220052,
+	-0.99144486137381, 0.130526192220051,
+	-0.793353340291235, 0.608761429008721,
+	-0.608761429008721, 0.793353340291235,
+	-0.130526192220052, 0.99144486137381,
+}
+
+// The normalized gradients, repeated to fill the table indexed by hashes.
+var openSimplexGradients = func() []float64 {
+	toReturn := make([]float64, 2<<openSimplexGradExp)
+	for i := range toReturn {
+		toReturn[i] = openSimplexBaseGradients[i%len(
+			openSimplexBaseGradients)] / openSimplexNormalizer
+	}
+	return toReturn
+}()
+
+// Implements the NoiseSource interface using the OpenSimplex2 algorithm,
+// which avoids the axis-aligned artifacts of Perlin noise.
+type openSimplex2Noise struct {
+	seed int64
+}
+
+// Returns a NoiseSource producing OpenSimplex2 noise, determined by the given
+// seed.
+func NewOpenSimplex2Noise(seed int64) NoiseSource {
+	return &openSimplex2Noise{
+		seed: seed,
+	}
+}
+
+// Returns the contribution of the gradient at the given (skewed and hashed)
+// lattice vertex to the offset (dx, dy).
+func (n *openSimplex2Noise) gradient(xsvp, ysvp uint64, dx,
+	dy float64) float64 {
+	hash := uint64(n.seed) ^ xsvp ^ ysvp
+	hash *= openSimplexHashMult
+	hash ^= hash >> (64 - openSimplexGradExp + 1)
+	gi := int(hash) & (((1 << openSimplexGradExp) - 1) << 1)
+	return openSimplexGradients[gi]*dx + openSimplexGradients[gi|1]*dy
+}
+
+// Returns the contribution of a single vertex with the given offset.
+func (n *openSimplex2Noise) contribution(xsvp, ysvp uint64, dx,
+	dy float64) float64 {
+	a := openSimplexRSquared - dx*dx - dy*dy
+	if a <= 0 {
+		return 0
+	}
+	return (a * a) * (a * a) * n.gradient(xsvp, ysvp, dx, dy)
+}
+
+func (n *openSimplex2Noise) Noise(x, y float64) float64 {
+	// Skew the input onto the simplex lattice.
+	s := openSimplexSkew * (x + y)
+	xs := x + s
+	ys := y + s
+	xsb := math.Floor(xs)
+	ysb := math.Floor(ys)
+	xi := xs - xsb
+	yi := ys - ysb
+	xsbp := uint64(int64(xsb)) * openSimplexPrimeX
+	ysbp := uint64(int64(ysb)) * openSimplexPrimeY
+
+	// Unskew to find the offset from the base vertex.
+	t := (xi + yi) * openSimplexUnskew
+	dx0 := xi + t
+	dy0 := yi + t
+	value := n.contribution(xsbp, ysbp, dx0, dy0)
+	dx1 := dx0 - (1 + 2*openSimplexUnskew)
+	dy1 := dy0 - (1 + 2*openSimplexUnskew)
+	value += n.contribution(xsbp+openSimplexPrimeX, ysbp+openSimplexPrimeY,
+		dx1, dy1)
+	if dy0 > dx0 {
+		value += n.contribution(xsbp, ysbp+openSimplexPrimeY,
+			dx0-openSimplexUnskew, dy0-(openSimplexUnskew+1))
+	} else {
+		value += n.contribution(xsbp+openSimplexPrimeX, ysbp,
+			dx0-(openSimplexUnskew+1), dy0-openSimplexUnskew)
+	}
+	return value
+}
+
+// Selects how the octaves of a NoiseImage are combined.
+type FractalMode int
+
+const (
+	// Fractal Brownian motion: the octaves are summed directly.
+	FractalBrownianMotion FractalMode = iota
+	// Ridged multifractal: each octave is inverted around its absolute
+	// value and squared, producing sharp ridges.
+	RidgedMultifractal
+	// Turbulence: the absolute values of the octaves are summed, producing
+	// billowy creases.
+	Turbulence
+)
+
+func (m FractalMode) String() string {
+	switch m {
+	case FractalBrownianMotion:
+		return "fBm"
+	case RidgedMultifractal:
+		return "ridged multifractal"
+	case Turbulence:
+		return "turbulence"
+	}
+	return fmt.Sprintf("unknown fractal mode %d", int(m))
+}
+
+// Implements the image.Image interface, lazily computing each pixel from one
+// or more octaves of a NoiseSource. Pixels are FloatGrayscale values in the
+// range [0, 1]. The fields may be changed at any time, and affect subsequent
+// calls to At.
+type NoiseImage struct {
+	// The underlying noise. The seed is chosen when creating the source.
+	Source NoiseSource
+	// The width and height of the image.
+	W, H int
+	// The frequency of the first octave, in noise units per pixel. For
+	// example, a frequency of 0.05 produces features about 20 pixels apart.
+	Frequency float64
+	// The number of octaves to combine.
+	Octaves int
+	// The factor by which the frequency increases with each octave.
+	Lacunarity float64
+	// The factor by which the amplitude is scaled with each octave.
+	Gain float64
+	// How the octaves are combined.
+	Mode FractalMode
+	// If true, the image wraps around at its edges so it can be tiled
+	// seamlessly. This blends the noise with shifted copies of itself, which
+	// slightly reduces contrast near the image's center.
+	Tileable bool
+}
+
+// Returns a new NoiseImage using the given source, dimensions and initial
+// frequency. The image uses a single octave of fractal Brownian motion, with
+// a lacunarity of 2 and a gain of 0.5; change the fields to adjust these.
+func NewNoiseImage(source NoiseSource, w, h int,
+	frequency float64) (*NoiseImage, error) {
+	if source == nil {
+		return nil, fmt.Errorf("A noise source is required")
+	}
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Image bounds must be positive")
+	}
+	if !(frequency > 0) {
+		return nil, fmt.Errorf("The frequency must be positive, got %f",
+			frequency)
+	}
+	return &NoiseImage{
+		Source:     source,
+		W:          w,
+		H:          h,
+		Frequency:  frequency,
+		Octaves:    1,
+		Lacunarity: 2.0,
+		Gain:       0.5,
+		Mode:       FractalBrownianMotion,
+	}, nil
+}
+
+func (n *NoiseImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, n.W, n.H)
+}
+
+func (n *NoiseImage) ColorModel() color.Model {
+	return color.ModelFunc(func(c color.Color) color.Color {
+		return ConvertToFloatGrayscale(c)
+	})
+}
+
+// Returns the combined octaves of noise at the given location, in pixels,
+// scaled to [0, 1].
+func (n *NoiseImage) fractalValue(x, y float64) float64 {
+	octaves := n.Octaves
+	if octaves < 1 {
+		octaves = 1
+	}
+	frequency := n.Frequency
+	amplitude := 1.0
+	sum := 0.0
+	totalAmplitude := 0.0
+	for i := 0; i < octaves; i++ {
+		v := n.Source.Noise(x*frequency, y*frequency)
+		switch n.Mode {
+		case RidgedMultifractal:
+			v = 1.0 - math.Abs(v)
+			v *= v
+		case Turbulence:
+			v = math.Abs(v)
+		default:
+			v = (v + 1.0) * 0.5
+		}
+		sum += v * amplitude
+		totalAmplitude += amplitude
+		frequency *= n.Lacunarity
+		amplitude *= n.Gain
+	}
+	if totalAmplitude == 0 {
+		return 0
+	}
+	return clamp(sum / totalAmplitude)
+}
+
+// Returns the value of the pixel at (x, y), in the range [0, 1].
+func (n *NoiseImage) Value(x, y int) float32 {
+	fx := float64(x)
+	fy := float64(y)
+	if !n.Tileable {
+		return float32(n.fractalValue(fx, fy))
+	}
+	// Blend the noise with copies shifted by the image's width and height,
+	// weighted so that opposite edges match.
+	w := float64(n.W)
+	h := float64(n.H)
+	tx := fx / w
+	ty := fy / h
+	v := n.fractalValue(fx, fy)*(1-tx)*(1-ty) +
+		n.fractalValue(fx-w, fy)*tx*(1-ty) +
+		n.fractalValue(fx, fy-h)*(1-tx)*ty +
+		n.fractalValue(fx-w, fy-h)*tx*ty
+	return float32(v)
+}
+
+func (n *NoiseImage) At(x, y int) color.Color {
+	return FloatGrayscale(n.Value(x, y))
+}
+
+// Computes every pixel of the noise image, returning the result as a new
+// FloatGrayscaleImage.
+func (n *NoiseImage) Rasterize() (*FloatGrayscaleImage, error) {
+	toReturn, e := NewFloatGrayscaleImage(n.W, n.H)
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < n.H; y++ {
+		for x := 0; x < n.W; x++ {
+			toReturn.Pixels[y*n.W+x] = n.Value(x, y)
+		}
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,82 @@
+package image_utils
+
+// This file contains a parallel implementation of the Jump-Flooding algorithm
+// used by VoronoiImage.
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Runs a single pass of the Jump-Flooding algorithm over rows [minY, maxY),
+// reading from src and writing to dst. Returns early if ctx is canceled.
+func (v *VoronoiImage) jumpFloodRows(ctx context.Context, src,
+	dst []VoronoiPixel, minY, maxY, stepSize int) {
+	for y := minY; y < maxY; y++ {
+		if ctx.Err() != nil {
+			return
+		}
+		for x := 0; x < v.W; x++ {
+			v.setSinglePixel(src, dst, x, y, stepSize)
+		}
+	}
+}
+
+// Like JumpFloodFill, but splits the rows of each pass across the given number
+// of goroutines. If workers is 0 or negative, runtime.NumCPU() workers are
+// used. Unlike JumpFloodFill, each pass reads from a separate copy of the
+// pixels from the previous pass, so the output doesn't depend on the order in
+// which pixels are processed, and is the same for any number of workers.
+// Returns an error if ctx is canceled before the fill completes, in which case
+// the pixels may be left partially filled.
+func (v *VoronoiImage) ParallelJumpFloodFill(ctx context.Context,
+	workers int) error {
+	if (v.W <= 0) || (v.H <= 0) {
+		return fmt.Errorf("Invalid image dimensions: %dx%d", v.W, v.H)
+	}
+	if len(v.Pixels) != (v.W * v.H) {
+		return fmt.Errorf("The image contains %d pixels, expected %dx%d",
+			len(v.Pixels), v.W, v.H)
+	}
+	e := v.prepareFill()
+	if e != nil {
+		return e
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > v.H {
+		workers = v.H
+	}
+	rowsPerWorker := (v.H + workers - 1) / workers
+	src := v.Pixels
+	dst := make([]VoronoiPixel, len(src))
+	var wg sync.WaitGroup
+	for _, stepSize := range v.jumpFloodSteps() {
+		for minY := 0; minY < v.H; minY += rowsPerWorker {
+			maxY := minY + rowsPerWorker
+			if maxY > v.H {
+				maxY = v.H
+			}
+			wg.Add(1)
+			go func(minY, maxY int) {
+				defer wg.Done()
+				v.jumpFloodRows(ctx, src, dst, minY, maxY, stepSize)
+			}(minY, maxY)
+		}
+		wg.Wait()
+		e = ctx.Err()
+		if e != nil {
+			return fmt.Errorf("Jump-Flood fill canceled: %w", e)
+		}
+		src, dst = dst, src
+	}
+	// The most recent pass wrote to src, which may be the scratch buffer
+	// rather than the original slice.
+	if &(src[0]) != &(v.Pixels[0]) {
+		copy(v.Pixels, src)
+	}
+	return nil
+}
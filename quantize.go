@@ -0,0 +1,676 @@
+package image_utils
+
+// This file contains several algorithms for choosing a limited palette of
+// colors that represents an image, for producing GIFs or indexed PNGs.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// A color, with components from 0 to 255, along with the number of pixels it
+// represents. Used as both the input and output of the quantizers in this
+// file. The quantizers' outputs are the averages of the colors they replace.
+type weightedColor struct {
+	c     [3]float64
+	count int
+}
+
+// Returns the color as an opaque color.RGBA.
+func (w *weightedColor) rgba() color.RGBA {
+	return color.RGBA{
+		R: uint8(math.Round(w.c[0])),
+		G: uint8(math.Round(w.c[1])),
+		B: uint8(math.Round(w.c[2])),
+		A: 0xff,
+	}
+}
+
+// Returns a list of the distinct colors in pic, and the number of times each
+// occurs, in a consistent order. Fully transparent pixels aren't included in
+// the list; they're counted separately. Partially transparent pixels are
+// treated as opaque, using their non-premultiplied colors. If include is
+// non-nil, only the opaque or partially transparent pixels for which it
+// returns true are included.
+func countColors(pic image.Image,
+	include func(c color.NRGBA) bool) ([]weightedColor, int) {
+	bounds := pic.Bounds().Canon()
+	counts := make(map[uint32]int)
+	transparent := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(pic.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				transparent++
+				continue
+			}
+			if (include != nil) && !include(c) {
+				continue
+			}
+			counts[uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B)]++
+		}
+	}
+	keys := make([]uint32, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a] < keys[b]
+	})
+	toReturn := make([]weightedColor, len(keys))
+	for i, k := range keys {
+		toReturn[i] = weightedColor{
+			c: [3]float64{float64(k >> 16), float64((k >> 8) & 0xff),
+				float64(k & 0xff)},
+			count: counts[k],
+		}
+	}
+	return toReturn, transparent
+}
+
+// Returns the average of the given colors, weighted by their counts. The
+// returned count is the total of the colors' counts.
+func averageColor(colors []weightedColor) weightedColor {
+	var toReturn weightedColor
+	for _, c := range colors {
+		for i := range c.c {
+			toReturn.c[i] += c.c[i] * float64(c.count)
+		}
+		toReturn.count += c.count
+	}
+	if toReturn.count > 0 {
+		for i := range toReturn.c {
+			toReturn.c[i] /= float64(toReturn.count)
+		}
+	}
+	return toReturn
+}
+
+// Selects the algorithm used to choose a palette.
+type QuantizationMethod int
+
+const (
+	// Heckbert's median cut algorithm, which repeatedly splits the box of
+	// colors with the largest range at its median.
+	MedianCutQuantization QuantizationMethod = iota
+	// Builds an octree of the image's colors, and merges the least common
+	// leaves until few enough remain. Fast, but tends to favor common colors
+	// over distinct ones.
+	OctreeQuantization
+	// Xiaolin Wu's algorithm, which splits boxes of colors to minimize the
+	// variance within each box. Usually produces better palettes than median
+	// cut, at a similar speed.
+	WuQuantization
+	// Refines a median cut palette using k-means clustering in the CIELAB
+	// color space, so that the palette fits perceptual differences. The
+	// slowest method, but usually produces the best palettes.
+	KMeansQuantization
+)
+
+func (m QuantizationMethod) String() string {
+	switch m {
+	case MedianCutQuantization:
+		return "median cut"
+	case OctreeQuantization:
+		return "octree"
+	case WuQuantization:
+		return "Wu"
+	case KMeansQuantization:
+		return "k-means"
+	}
+	return fmt.Sprintf("unknown quantization method %d", int(m))
+}
+
+// Reduces the given colors to at most count representative colors, sorted
+// from most to least common.
+func quantizeColors(colors []weightedColor, count int,
+	method QuantizationMethod) ([]weightedColor, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("The number of colors must be positive, got %d",
+			count)
+	}
+	var toReturn []weightedColor
+	if len(colors) <= count {
+		toReturn = make([]weightedColor, len(colors))
+		copy(toReturn, colors)
+	} else {
+		switch method {
+		case MedianCutQuantization:
+			toReturn = medianCut(colors, count)
+		case OctreeQuantization:
+			toReturn = octreeQuantize(colors, count)
+		case WuQuantization:
+			toReturn = wuQuantize(colors, count)
+		case KMeansQuantization:
+			toReturn = kMeansLab(colors, count)
+		default:
+			return nil, fmt.Errorf("Invalid quantization method: %s", method)
+		}
+	}
+	sort.SliceStable(toReturn, func(a, b int) bool {
+		return toReturn[a].count > toReturn[b].count
+	})
+	return toReturn, nil
+}
+
+// Returns a palette of at most count colors representing pic, chosen using the
+// given method. If pic contains any fully transparent pixels and count is at
+// least 2, the palette's last entry is transparent, and the other colors are
+// chosen from the remaining pixels. Otherwise, every entry is opaque, and the
+// entries are sorted from most to least common.
+func QuantizePalette(pic image.Image, count int,
+	method QuantizationMethod) (color.Palette, error) {
+	colors, transparent := countColors(pic, nil)
+	reserveTransparent := (transparent > 0) && (count >= 2)
+	if reserveTransparent {
+		count--
+	}
+	if (len(colors) == 0) && !reserveTransparent {
+		return nil, fmt.Errorf("The image doesn't contain any visible pixels")
+	}
+	quantized, e := quantizeColors(colors, count, method)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := make(color.Palette, 0, len(quantized)+1)
+	for i := range quantized {
+		toReturn = append(toReturn, quantized[i].rgba())
+	}
+	if reserveTransparent {
+		toReturn = append(toReturn, color.RGBA{})
+	}
+	return toReturn, nil
+}
+
+// Returns a copy of pic, with the same bounds, where each pixel is replaced by
+// the closest color in the palette. The palette must contain between 1 and 256
+// colors. See QuantizePalette for choosing a palette, or DitherToPalette for
+// reducing banding.
+func ToPaletted(pic image.Image, p color.Palette) (*image.Paletted, error) {
+	if (len(p) == 0) || (len(p) > 256) {
+		return nil, fmt.Errorf("The palette must contain 1 to 256 colors, "+
+			"got %d", len(p))
+	}
+	bounds := pic.Bounds()
+	toReturn := image.NewPaletted(bounds, p)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			toReturn.SetColorIndex(x, y, uint8(p.Index(pic.At(x, y))))
+		}
+	}
+	return toReturn, nil
+}
+
+// A convenience function combining QuantizePalette and ToPaletted.
+func QuantizeImage(pic image.Image, count int,
+	method QuantizationMethod) (*image.Paletted, error) {
+	p, e := QuantizePalette(pic, count, method)
+	if e != nil {
+		return nil, e
+	}
+	return ToPaletted(pic, p)
+}
+
+// Returns the channel along which the colors have the largest range, and the
+// size of that range.
+func longestAxis(colors []weightedColor) (int, float64) {
+	minValues := colors[0].c
+	maxValues := colors[0].c
+	for _, c := range colors[1:] {
+		for i, v := range c.c {
+			minValues[i] = math.Min(minValues[i], v)
+			maxValues[i] = math.Max(maxValues[i], v)
+		}
+	}
+	axis := 0
+	for i := 1; i < 3; i++ {
+		if (maxValues[i] - minValues[i]) > (maxValues[axis] - minValues[axis]) {
+			axis = i
+		}
+	}
+	return axis, maxValues[axis] - minValues[axis]
+}
+
+// Implements MedianCutQuantization. The colors slice is reordered.
+func medianCut(colors []weightedColor, count int) []weightedColor {
+	boxes := [][]weightedColor{colors}
+	// The longest axis and range of each box, which don't change until the
+	// box is split.
+	axes := make([]int, 1, count)
+	ranges := make([]float64, 1, count)
+	axes[0], ranges[0] = longestAxis(colors)
+	for len(boxes) < count {
+		// Split the box with the largest range in any channel. Boxes with a
+		// single color have no range.
+		toSplit := -1
+		for i := range boxes {
+			if (ranges[i] > 0) && ((toSplit < 0) ||
+				(ranges[i] > ranges[toSplit])) {
+				toSplit = i
+			}
+		}
+		if toSplit < 0 {
+			break
+		}
+		axis := axes[toSplit]
+		box := boxes[toSplit]
+		sort.SliceStable(box, func(a, b int) bool {
+			return box[a].c[axis] < box[b].c[axis]
+		})
+		// Split at the median pixel, rather than the median distinct color,
+		// but keep at least one color in each half.
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		split := 1
+		sum := box[0].count
+		for (split < len(box)-1) && (sum*2 < total) {
+			sum += box[split].count
+			split++
+		}
+		boxes[toSplit] = box[:split]
+		boxes = append(boxes, box[split:])
+		axes[toSplit], ranges[toSplit] = longestAxis(boxes[toSplit])
+		axis, boxRange := longestAxis(boxes[len(boxes)-1])
+		axes = append(axes, axis)
+		ranges = append(ranges, boxRange)
+	}
+	toReturn := make([]weightedColor, len(boxes))
+	for i, box := range boxes {
+		toReturn[i] = averageColor(box)
+	}
+	return toReturn
+}
+
+// A node in the tree used by octreeQuantize.
+type octreeNode struct {
+	level    int
+	isLeaf   bool
+	count    int
+	sum      [3]float64
+	children [8]*octreeNode
+}
+
+// Implements OctreeQuantization. Each level of the tree distinguishes colors
+// by one more bit of each channel, so the leaves at the deepest level hold
+// individual colors. Merging a node's children into it replaces their colors
+// with their average.
+func octreeQuantize(colors []weightedColor, count int) []weightedColor {
+	root := &octreeNode{}
+	// The non-leaf nodes at each level, which may be merged.
+	reducible := make([][]*octreeNode, 8)
+	reducible[0] = append(reducible[0], root)
+	leafCount := 0
+	for _, c := range colors {
+		node := root
+		for !node.isLeaf {
+			node.count += c.count
+			bit := uint(7 - node.level)
+			index := 0
+			for i := range c.c {
+				index |= ((int(c.c[i]) >> bit) & 1) << uint(i)
+			}
+			child := node.children[index]
+			if child == nil {
+				child = &octreeNode{
+					level: node.level + 1,
+				}
+				if child.level == 8 {
+					child.isLeaf = true
+					leafCount++
+				} else {
+					reducible[child.level] = append(reducible[child.level],
+						child)
+				}
+				node.children[index] = child
+			}
+			node = child
+		}
+		node.count += c.count
+		for i := range c.c {
+			node.sum[i] += c.c[i] * float64(c.count)
+		}
+	}
+
+	// Merge the least common nodes at the deepest level until few enough
+	// leaves remain. Merging doesn't change the counts of the other nodes at
+	// the same level, so each level only needs to be sorted once.
+	level := 8
+	for leafCount > count {
+		for (level == 8) || (len(reducible[level]) == 0) {
+			level--
+			nodes := reducible[level]
+			sort.SliceStable(nodes, func(a, b int) bool {
+				return nodes[a].count > nodes[b].count
+			})
+		}
+		nodes := reducible[level]
+		node := nodes[len(nodes)-1]
+		reducible[level] = nodes[:len(nodes)-1]
+		for i, child := range node.children {
+			if child == nil {
+				continue
+			}
+			for j := range node.sum {
+				node.sum[j] += child.sum[j]
+			}
+			node.children[i] = nil
+			leafCount--
+		}
+		node.isLeaf = true
+		leafCount++
+	}
+
+	toReturn := make([]weightedColor, 0, leafCount)
+	var collectLeaves func(n *octreeNode)
+	collectLeaves = func(n *octreeNode) {
+		if n.isLeaf {
+			c := weightedColor{count: n.count}
+			for i := range c.c {
+				c.c[i] = n.sum[i] / float64(n.count)
+			}
+			toReturn = append(toReturn, c)
+			return
+		}
+		for _, child := range n.children {
+			if child != nil {
+				collectLeaves(child)
+			}
+		}
+	}
+	collectLeaves(root)
+	return toReturn
+}
+
+// The number of cells along each axis of the histogram used by Wu's
+// algorithm. Each channel is reduced to 5 bits, and the extra cell holds
+// zeros so that cumulative sums don't need special cases.
+const wuSize = 33
+
+// Returns the index of a cell in the histogram used by Wu's algorithm.
+func wuIndex(r, g, b int) int {
+	return r*wuSize*wuSize + g*wuSize + b
+}
+
+// Holds cumulative moments of the colors for Wu's algorithm. After
+// initialization, each cell holds the sums over all cells with lower or equal
+// coordinates, so sums over any box can be computed in constant time.
+type wuHistogram struct {
+	weight, red, green, blue, squares []float64
+}
+
+// A box of histogram cells. The minimum coordinates are exclusive and the
+// maximum coordinates are inclusive.
+type wuBox struct {
+	min, max [3]int
+}
+
+// Returns the number of cells in the box.
+func (b *wuBox) volume() int {
+	return (b.max[0] - b.min[0]) * (b.max[1] - b.min[1]) *
+		(b.max[2] - b.min[2])
+}
+
+// Returns the sum of the given moment over the box.
+func (b *wuBox) sum(m []float64) float64 {
+	r0, g0, b0 := b.min[0], b.min[1], b.min[2]
+	r1, g1, b1 := b.max[0], b.max[1], b.max[2]
+	return m[wuIndex(r1, g1, b1)] - m[wuIndex(r1, g1, b0)] -
+		m[wuIndex(r1, g0, b1)] + m[wuIndex(r1, g0, b0)] -
+		m[wuIndex(r0, g1, b1)] + m[wuIndex(r0, g1, b0)] +
+		m[wuIndex(r0, g0, b1)] - m[wuIndex(r0, g0, b0)]
+}
+
+// Returns the weight and the sum of each channel over the box.
+func (h *wuHistogram) boxSums(b *wuBox) (float64, [3]float64) {
+	return b.sum(h.weight), [3]float64{b.sum(h.red), b.sum(h.green),
+		b.sum(h.blue)}
+}
+
+// Returns the weighted variance of the colors in the box.
+func (h *wuHistogram) variance(b *wuBox) float64 {
+	weight, sums := h.boxSums(b)
+	if weight == 0 {
+		return 0
+	}
+	squaredSum := sums[0]*sums[0] + sums[1]*sums[1] + sums[2]*sums[2]
+	return b.sum(h.squares) - squaredSum/weight
+}
+
+// Finds the best place to split the box along the given axis, maximizing the
+// sum over both halves of the squared channel sums divided by the weight,
+// which is equivalent to minimizing the halves' total variance. Returns the
+// maximized value and the new maximum coordinate of the lower half, or -1 if
+// the box can't be split along the axis.
+func (h *wuHistogram) maximize(b *wuBox, axis int) (float64, int) {
+	wholeWeight, wholeSums := h.boxSums(b)
+	best := 0.0
+	cut := -1
+	for i := b.min[axis] + 1; i < b.max[axis]; i++ {
+		lower := *b
+		lower.max[axis] = i
+		lowerWeight, lowerSums := h.boxSums(&lower)
+		upperWeight := wholeWeight - lowerWeight
+		if (lowerWeight == 0) || (upperWeight == 0) {
+			continue
+		}
+		value := 0.0
+		for j := range lowerSums {
+			upperSum := wholeSums[j] - lowerSums[j]
+			value += lowerSums[j]*lowerSums[j]/lowerWeight +
+				upperSum*upperSum/upperWeight
+		}
+		if value > best {
+			best = value
+			cut = i
+		}
+	}
+	return best, cut
+}
+
+// Implements WuQuantization. Based on Xiaolin Wu's "Efficient Statistical
+// Computations for Optimal Color Quantization", in Graphics Gems II.
+func wuQuantize(colors []weightedColor, count int) []weightedColor {
+	cells := wuSize * wuSize * wuSize
+	h := &wuHistogram{
+		weight:  make([]float64, cells),
+		red:     make([]float64, cells),
+		green:   make([]float64, cells),
+		blue:    make([]float64, cells),
+		squares: make([]float64, cells),
+	}
+	for _, c := range colors {
+		i := wuIndex((int(c.c[0])>>3)+1, (int(c.c[1])>>3)+1,
+			(int(c.c[2])>>3)+1)
+		w := float64(c.count)
+		h.weight[i] += w
+		h.red[i] += c.c[0] * w
+		h.green[i] += c.c[1] * w
+		h.blue[i] += c.c[2] * w
+		h.squares[i] += (c.c[0]*c.c[0] + c.c[1]*c.c[1] + c.c[2]*c.c[2]) * w
+	}
+	// Convert each moment to cumulative sums.
+	for _, m := range [][]float64{h.weight, h.red, h.green, h.blue,
+		h.squares} {
+		for r := 1; r < wuSize; r++ {
+			var area [wuSize]float64
+			for g := 1; g < wuSize; g++ {
+				line := 0.0
+				for b := 1; b < wuSize; b++ {
+					line += m[wuIndex(r, g, b)]
+					area[b] += line
+					m[wuIndex(r, g, b)] = m[wuIndex(r-1, g, b)] + area[b]
+				}
+			}
+		}
+	}
+
+	boxes := make([]wuBox, 1, count)
+	boxes[0].max = [3]int{wuSize - 1, wuSize - 1, wuSize - 1}
+	variances := []float64{h.variance(&boxes[0])}
+	for len(boxes) < count {
+		// Split the box with the largest variance.
+		next := 0
+		for i := range variances {
+			if variances[i] > variances[next] {
+				next = i
+			}
+		}
+		if variances[next] <= 0 {
+			break
+		}
+		b := &boxes[next]
+		axis := -1
+		best := 0.0
+		cut := -1
+		for i := 0; i < 3; i++ {
+			value, position := h.maximize(b, i)
+			if (position >= 0) && (value > best) {
+				best = value
+				cut = position
+				axis = i
+			}
+		}
+		if axis < 0 {
+			// The box can't be split, so don't consider it again.
+			variances[next] = 0
+			continue
+		}
+		upper := *b
+		b.max[axis] = cut
+		upper.min[axis] = cut
+		boxes = append(boxes, upper)
+		variances = append(variances, 0)
+		for _, i := range []int{next, len(boxes) - 1} {
+			variances[i] = 0
+			if boxes[i].volume() > 1 {
+				variances[i] = h.variance(&boxes[i])
+			}
+		}
+	}
+
+	toReturn := make([]weightedColor, 0, len(boxes))
+	for i := range boxes {
+		weight, sums := h.boxSums(&boxes[i])
+		if weight == 0 {
+			continue
+		}
+		toReturn = append(toReturn, weightedColor{
+			c: [3]float64{sums[0] / weight, sums[1] / weight,
+				sums[2] / weight},
+			count: int(weight),
+		})
+	}
+	return toReturn
+}
+
+// Converts a color with components from 0 to 255 to CIELAB, relative to D65.
+func weightedColorToLab(c [3]float64) [3]float64 {
+	x, y, z := linearSRGBToXYZ.apply(SRGBToLinear(c[0]/255.0),
+		SRGBToLinear(c[1]/255.0), SRGBToLinear(c[2]/255.0))
+	lab := XYZToLab(XYZColor{X: x, Y: y, Z: z}, D65WhitePoint)
+	return [3]float64{lab.L, lab.A, lab.B}
+}
+
+// The inverse of weightedColorToLab. Clamps colors outside of the sRGB gamut.
+func labToWeightedColor(lab [3]float64) [3]float64 {
+	xyz := LabColor{L: lab[0], A: lab[1], B: lab[2]}.XYZ()
+	r, g, b := xyzToLinearSRGB.apply(xyz.X, xyz.Y, xyz.Z)
+	return [3]float64{LinearToSRGB(clamp(r)) * 255.0,
+		LinearToSRGB(clamp(g)) * 255.0, LinearToSRGB(clamp(b)) * 255.0}
+}
+
+// Implements KMeansQuantization. To keep the cost manageable for images with
+// many distinct colors, the colors are first grouped into buckets using 5 bits
+// per channel, and the clusters are computed using the buckets' averages.
+func kMeansLab(colors []weightedColor, count int) []weightedColor {
+	maxIterations := 20
+	buckets := make(map[int][]weightedColor)
+	for _, c := range colors {
+		key := wuIndex(int(c.c[0])>>3, int(c.c[1])>>3, int(c.c[2])>>3)
+		buckets[key] = append(buckets[key], c)
+	}
+	keys := make([]int, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	points := make([]weightedColor, len(keys))
+	for i, k := range keys {
+		points[i] = averageColor(buckets[k])
+		points[i].c = weightedColorToLab(points[i].c)
+	}
+
+	// Start with the median cut palette. The colors are copied since
+	// medianCut reorders them.
+	initial := make([]weightedColor, len(colors))
+	copy(initial, colors)
+	centers := medianCut(initial, count)
+	for i := range centers {
+		centers[i].c = weightedColorToLab(centers[i].c)
+	}
+	assignments := make([]int, len(points))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+	sums := make([]weightedColor, len(centers))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := false
+		for i := range sums {
+			sums[i] = weightedColor{}
+		}
+		for i, p := range points {
+			closest := 0
+			closestDistance := math.Inf(1)
+			for j := range centers {
+				distance := 0.0
+				for k := range p.c {
+					d := p.c[k] - centers[j].c[k]
+					distance += d * d
+				}
+				if distance < closestDistance {
+					closest = j
+					closestDistance = distance
+				}
+			}
+			if assignments[i] != closest {
+				assignments[i] = closest
+				changed = true
+			}
+			for k := range p.c {
+				sums[closest].c[k] += p.c[k] * float64(p.count)
+			}
+			sums[closest].count += p.count
+		}
+		// Move each center to the average of its points. Centers without
+		// any points stay where they are.
+		for i := range centers {
+			centers[i].count = sums[i].count
+			if sums[i].count == 0 {
+				continue
+			}
+			for k := range centers[i].c {
+				centers[i].c[k] = sums[i].c[k] / float64(sums[i].count)
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	toReturn := make([]weightedColor, 0, len(centers))
+	for _, c := range centers {
+		if c.count == 0 {
+			continue
+		}
+		toReturn = append(toReturn, weightedColor{
+			c:     labToWeightedColor(c.c),
+			count: c.count,
+		})
+	}
+	return toReturn
+}
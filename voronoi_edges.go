@@ -0,0 +1,177 @@
+package image_utils
+
+// This file contains functions for finding and drawing the boundaries between
+// the cells in a filled VoronoiImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Implements the ShapeWalker interface for a fixed list of points.
+type pointListWalker struct {
+	points []image.Point
+	index  int
+}
+
+func (w *pointListWalker) Reset() {
+	w.index = 0
+}
+
+func (w *pointListWalker) Done() bool {
+	return w.index >= len(w.points)
+}
+
+func (w *pointListWalker) Next() image.Point {
+	if w.Done() {
+		// Arbitrarily keep returning the final point, like rectangleWalker
+		// continuing along its last edge.
+		if len(w.points) == 0 {
+			return image.Point{}
+		}
+		return w.points[len(w.points)-1]
+	}
+	toReturn := w.points[w.index]
+	w.index++
+	return toReturn
+}
+
+// Returns true if the two pixels belong to different cells.
+func differentCells(a, b *VoronoiPixel) bool {
+	if a.IsDefined != b.IsDefined {
+		return true
+	}
+	return a.IsDefined && (a.SeedLocation != b.SeedLocation)
+}
+
+// Returns a slice of WxH bools, set to true for each pixel on the boundary
+// between two cells. A pixel is on a boundary if the pixel to its right or
+// below it belongs to a different cell, so boundaries are one pixel thick.
+func (v *VoronoiImage) edgeMask() []bool {
+	toReturn := make([]bool, v.W*v.H)
+	for y := 0; y < v.H; y++ {
+		for x := 0; x < v.W; x++ {
+			p := v.At(x, y)
+			if (x+1 < v.W) && differentCells(p, v.At(x+1, y)) {
+				toReturn[y*v.W+x] = true
+			} else if (y+1 < v.H) && differentCells(p, v.At(x, y+1)) {
+				toReturn[y*v.W+x] = true
+			}
+		}
+	}
+	return toReturn
+}
+
+// Returns the location of every pixel on the boundary between two cells, in
+// order from left to right and top to bottom. Intended to be called after
+// JumpFloodFill. Cells are distinguished by their seed locations, so
+// boundaries are found even between cells with the same color.
+func (v *VoronoiImage) EdgePoints() []image.Point {
+	mask := v.edgeMask()
+	toReturn := make([]image.Point, 0, 256)
+	for i, isEdge := range mask {
+		if isEdge {
+			toReturn = append(toReturn, image.Pt(i%v.W, i/v.W))
+		}
+	}
+	return toReturn
+}
+
+// Returns a ShapeWalker that visits each of the points returned by
+// EdgePoints.
+func (v *VoronoiImage) EdgeWalker() ShapeWalker {
+	return &pointListWalker{
+		points: v.EdgePoints(),
+	}
+}
+
+// Offsets to neighboring pixels, with the directly adjacent pixels first so
+// that polylines prefer them over diagonals.
+var neighborOffsets = []image.Point{
+	{1, 0}, {0, 1}, {-1, 0}, {0, -1},
+	{1, 1}, {-1, 1}, {-1, -1}, {1, -1},
+}
+
+// Extends the polyline starting at p by repeatedly moving to an adjacent,
+// unvisited, edge pixel. Marks each pixel it visits. Returns the points it
+// added, not including p.
+func (v *VoronoiImage) traceEdge(mask, visited []bool,
+	p image.Point) []image.Point {
+	toReturn := make([]image.Point, 0, 64)
+	for {
+		found := false
+		for _, offset := range neighborOffsets {
+			n := p.Add(offset)
+			if (n.X < 0) || (n.Y < 0) || (n.X >= v.W) || (n.Y >= v.H) {
+				continue
+			}
+			i := n.Y*v.W + n.X
+			if !mask[i] || visited[i] {
+				continue
+			}
+			visited[i] = true
+			toReturn = append(toReturn, n)
+			p = n
+			found = true
+			break
+		}
+		if !found {
+			return toReturn
+		}
+	}
+}
+
+// Groups the boundary pixels returned by EdgePoints into polylines, where each
+// point in a polyline is adjacent (possibly diagonally) to the previous point.
+// Each boundary pixel appears in exactly one polyline. Polylines may end at
+// junctions where three or more cells meet.
+func (v *VoronoiImage) EdgePolylines() [][]image.Point {
+	mask := v.edgeMask()
+	visited := make([]bool, len(mask))
+	toReturn := make([][]image.Point, 0, 64)
+	for i, isEdge := range mask {
+		if !isEdge || visited[i] {
+			continue
+		}
+		visited[i] = true
+		start := image.Pt(i%v.W, i/v.W)
+		forward := v.traceEdge(mask, visited, start)
+		backward := v.traceEdge(mask, visited, start)
+		polyline := make([]image.Point, 0, len(forward)+len(backward)+1)
+		for j := len(backward) - 1; j >= 0; j-- {
+			polyline = append(polyline, backward[j])
+		}
+		polyline = append(polyline, start)
+		polyline = append(polyline, forward...)
+		toReturn = append(toReturn, polyline)
+	}
+	return toReturn
+}
+
+// Draws the boundaries between the cells in v to dst, using the given color.
+// Each boundary pixel is drawn as a square with sides of the given thickness,
+// which must be positive. The boundary pixels are drawn at the same
+// coordinates in dst as in v.
+func DrawVoronoiEdges(v *VoronoiImage, c color.Color, thickness int,
+	dst DrawableImage) error {
+	if thickness <= 0 {
+		return fmt.Errorf("The edge thickness must be positive, got %d",
+			thickness)
+	}
+	stamp, e := RectangleStructuringElement(thickness, thickness)
+	if e != nil {
+		return fmt.Errorf("Error creating the edge shape: %w", e)
+	}
+	mask := v.edgeMask()
+	for i, isEdge := range mask {
+		if !isEdge {
+			continue
+		}
+		p := image.Pt(i%v.W, i/v.W)
+		for _, offset := range stamp.Offsets {
+			dst.Set(p.X+offset.X, p.Y+offset.Y, c)
+		}
+	}
+	return nil
+}
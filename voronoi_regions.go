@@ -0,0 +1,192 @@
+package image_utils
+
+// This file contains functions for extracting information about the
+// individual cells in a filled VoronoiImage.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// A point with floating-point coordinates.
+type FloatPoint struct {
+	X, Y float64
+}
+
+func (p FloatPoint) String() string {
+	return fmt.Sprintf("(%f, %f)", p.X, p.Y)
+}
+
+// Returns the closest image.Point to p.
+func (p FloatPoint) Round() image.Point {
+	return image.Pt(roundToInt(p.X), roundToInt(p.Y))
+}
+
+// Rounds v to the closest integer, with halves rounded away from zero.
+func roundToInt(v float64) int {
+	if v < 0 {
+		return -int(-v + 0.5)
+	}
+	return int(v + 0.5)
+}
+
+// Describes a single cell in a filled VoronoiImage.
+type VoronoiCell struct {
+	// The location of the cell's seed.
+	Seed image.Point
+	// The color of the cell's seed.
+	Color color.Color
+	// The number of pixels in the cell.
+	Area int
+	// The average location of the pixels in the cell. Will be the seed's
+	// location if the cell is empty.
+	Centroid FloatPoint
+	// The smallest rectangle containing every pixel in the cell.
+	Bounds image.Rectangle
+	// The labels of the cells that share an edge with this cell, in
+	// increasing order.
+	Neighbors []int32
+}
+
+// Holds a label for each pixel in a VoronoiImage, identifying the cell it
+// belongs to. Unlike the colors written by VoronoiFill, labels are unique to
+// each seed, even if several seeds have the same color.
+type VoronoiLabels struct {
+	// The width and height of the labeled image.
+	W, H int
+	// Contains WxH labels. Each label is an index into Cells, or -1 for
+	// pixels that haven't been assigned to a seed.
+	Labels []int32
+	// Information about each cell, in the row-major order of the seeds.
+	Cells []VoronoiCell
+}
+
+// Returns the label of the pixel at (x, y), or -1 if (x, y) is out of bounds
+// or wasn't assigned to a cell.
+func (l *VoronoiLabels) LabelAt(x, y int) int32 {
+	if (x < 0) || (y < 0) || (x >= l.W) || (y >= l.H) {
+		return -1
+	}
+	return l.Labels[y*l.W+x]
+}
+
+// Returns a label map and cell information for the image. Intended to be
+// called after JumpFloodFill. Cells are numbered in the order their seeds
+// appear, from left to right and top to bottom. Returns an error if any pixel
+// refers to a seed location that isn't a seed.
+func (v *VoronoiImage) Label() (*VoronoiLabels, error) {
+	w := v.W
+	h := v.H
+	if len(v.Pixels) != (w * h) {
+		return nil, fmt.Errorf("The image contains %d pixels, expected %dx%d",
+			len(v.Pixels), w, h)
+	}
+	labels := make([]int32, w*h)
+	cells := make([]VoronoiCell, 0, 64)
+
+	// First, number the seeds. The labels slice temporarily holds the label of
+	// the seed at each location, if there is one.
+	for i := range v.Pixels {
+		labels[i] = -1
+		p := &(v.Pixels[i])
+		if !p.IsSeed {
+			continue
+		}
+		labels[i] = int32(len(cells))
+		cells = append(cells, VoronoiCell{
+			Seed:      image.Pt(i%w, i/w),
+			Color:     p.Color,
+			Neighbors: make([]int32, 0, 8),
+		})
+	}
+	seedLabels := make([]int32, w*h)
+	copy(seedLabels, labels)
+
+	// Next, label each pixel and accumulate the area, centroid and bounds of
+	// each cell.
+	sums := make([]FloatPoint, len(cells))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			p := &(v.Pixels[i])
+			if !p.IsDefined {
+				labels[i] = -1
+				continue
+			}
+			seed := p.SeedLocation
+			if !seed.In(v.Bounds()) || (seedLabels[seed.Y*w+seed.X] < 0) {
+				return nil, fmt.Errorf("The pixel at (%d, %d) refers to a "+
+					"seed at %s, which isn't a seed", x, y, seed)
+			}
+			label := seedLabels[seed.Y*w+seed.X]
+			labels[i] = label
+			cell := &(cells[label])
+			pixelRect := image.Rect(x, y, x+1, y+1)
+			if cell.Area == 0 {
+				cell.Bounds = pixelRect
+			} else {
+				cell.Bounds = cell.Bounds.Union(pixelRect)
+			}
+			cell.Area++
+			sums[label].X += float64(x)
+			sums[label].Y += float64(y)
+		}
+	}
+	for i := range cells {
+		cell := &(cells[i])
+		if cell.Area == 0 {
+			cell.Centroid = FloatPoint{
+				X: float64(cell.Seed.X),
+				Y: float64(cell.Seed.Y),
+			}
+			continue
+		}
+		cell.Centroid = FloatPoint{
+			X: sums[i].X / float64(cell.Area),
+			Y: sums[i].Y / float64(cell.Area),
+		}
+	}
+
+	// Finally, find the adjacent cells by comparing each pixel to its right
+	// and lower neighbors.
+	adjacent := make(map[[2]int32]bool)
+	addPair := func(a, b int32) {
+		if (a < 0) || (b < 0) || (a == b) {
+			return
+		}
+		if a > b {
+			a, b = b, a
+		}
+		adjacent[[2]int32{a, b}] = true
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			label := labels[y*w+x]
+			if x+1 < w {
+				addPair(label, labels[y*w+x+1])
+			}
+			if y+1 < h {
+				addPair(label, labels[(y+1)*w+x])
+			}
+		}
+	}
+	for pair := range adjacent {
+		cells[pair[0]].Neighbors = append(cells[pair[0]].Neighbors, pair[1])
+		cells[pair[1]].Neighbors = append(cells[pair[1]].Neighbors, pair[0])
+	}
+	for i := range cells {
+		neighbors := cells[i].Neighbors
+		sort.Slice(neighbors, func(a, b int) bool {
+			return neighbors[a] < neighbors[b]
+		})
+	}
+
+	return &VoronoiLabels{
+		W:      w,
+		H:      h,
+		Labels: labels,
+		Cells:  cells,
+	}, nil
+}
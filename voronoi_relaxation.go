@@ -0,0 +1,220 @@
+package image_utils
+
+// This file contains functions for Lloyd relaxation, which iteratively moves
+// Voronoi seeds to the centroids of their cells, and weighted Voronoi
+// stippling, which builds on it.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+// Returns a FloatGrayscaleImage with the same dimensions as pic, where each
+// pixel is 1.0 minus the brightness of the corresponding pixel in pic. Useful
+// as a density image for LloydRelaxation, so that seeds gather in darker
+// regions.
+func InvertedBrightness(pic image.Image) (*FloatGrayscaleImage, error) {
+	bounds := pic.Bounds().Canon()
+	toReturn, e := NewFloatGrayscaleImage(bounds.Dx(), bounds.Dy())
+	if e != nil {
+		return nil, e
+	}
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			toReturn.Pixels[i] = 1.0 -
+				float32(ConvertToFloatGrayscale(pic.At(x, y)))
+			i++
+		}
+	}
+	return toReturn, nil
+}
+
+// Runs a single iteration of Lloyd relaxation, returning the new seed
+// locations and whether any seed moved.
+func lloydStep(w, h int, seeds []image.Point,
+	density *FloatGrayscaleImage) ([]image.Point, bool, error) {
+	v, e := NewVoronoiImage(w, h)
+	if e != nil {
+		return nil, false, e
+	}
+	v.Options = &VoronoiOptions{
+		Variant: JumpFloodPlusOne,
+	}
+	for _, p := range seeds {
+		e = v.AddSeed(p, color.White)
+		if e != nil {
+			return nil, false, e
+		}
+	}
+	e = v.JumpFloodFill()
+	if e != nil {
+		return nil, false, fmt.Errorf("Error filling Voronoi cells: %w", e)
+	}
+	labels, e := v.Label()
+	if e != nil {
+		return nil, false, fmt.Errorf("Error labeling Voronoi cells: %w", e)
+	}
+
+	// Compute the weighted centroid of each cell.
+	sums := make([]FloatPoint, len(labels.Cells))
+	weights := make([]float64, len(labels.Cells))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			label := labels.Labels[i]
+			if label < 0 {
+				continue
+			}
+			weight := 1.0
+			if density != nil {
+				weight = float64(density.Pixels[i])
+				if !(weight > 0) {
+					continue
+				}
+			}
+			sums[label].X += float64(x) * weight
+			sums[label].Y += float64(y) * weight
+			weights[label] += weight
+		}
+	}
+
+	// Move each seed to its cell's centroid, unless another seed has already
+	// claimed that pixel.
+	occupied := make(map[image.Point]bool)
+	toReturn := make([]image.Point, 0, len(seeds))
+	moved := false
+	for i := range labels.Cells {
+		original := labels.Cells[i].Seed
+		p := original
+		if weights[i] > 0 {
+			p = FloatPoint{
+				X: sums[i].X / weights[i],
+				Y: sums[i].Y / weights[i],
+			}.Round()
+		}
+		if occupied[p] {
+			p = original
+		}
+		if occupied[p] {
+			// Both the centroid and the original location were taken, so
+			// merge this seed with the other one.
+			moved = true
+			continue
+		}
+		occupied[p] = true
+		if p != original {
+			moved = true
+		}
+		toReturn = append(toReturn, p)
+	}
+	return toReturn, moved, nil
+}
+
+// Runs the given number of iterations of Lloyd relaxation on the seeds, in an
+// image with the given dimensions. Each iteration computes the Voronoi cells
+// of the seeds and moves each seed to the centroid of its cell, rounded to
+// the nearest pixel. If density is non-nil, it must have the same dimensions
+// as the image, and each pixel's contribution to its cell's centroid is
+// weighted by its value in density. Stops early if no seed moves. Returns the
+// final seed locations, in an arbitrary order. Seeds are merged if they end up
+// on the same pixel, so fewer seeds may be returned than were given.
+func LloydRelaxation(w, h int, seeds []image.Point, iterations int,
+	density *FloatGrayscaleImage) ([]image.Point, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("At least one seed is required")
+	}
+	if iterations < 0 {
+		return nil, fmt.Errorf("The number of iterations can't be negative")
+	}
+	if (density != nil) && ((density.W != w) || (density.H != h)) {
+		return nil, fmt.Errorf("The density image is %dx%d, expected %dx%d",
+			density.W, density.H, w, h)
+	}
+	bounds := image.Rect(0, 0, w, h)
+	current := make([]image.Point, 0, len(seeds))
+	occupied := make(map[image.Point]bool)
+	for _, p := range seeds {
+		if !p.In(bounds) {
+			return nil, fmt.Errorf("Seed %s is outside of the image", p)
+		}
+		if occupied[p] {
+			continue
+		}
+		occupied[p] = true
+		current = append(current, p)
+	}
+	for i := 0; i < iterations; i++ {
+		next, moved, e := lloydStep(w, h, current, density)
+		if e != nil {
+			return nil, fmt.Errorf("Error in Lloyd iteration %d: %w", i, e)
+		}
+		current = next
+		if !moved {
+			break
+		}
+	}
+	return current, nil
+}
+
+// Returns a set of stipple points approximating the dark regions of pic, using
+// weighted Voronoi stippling. The initial points are chosen randomly with a
+// probability proportional to each pixel's darkness, using the given seed for
+// the random number generator, and are then refined using the given number of
+// iterations of Lloyd relaxation weighted by the image's inverted brightness.
+// The points are in the coordinates of pic's bounds, relative to its top-left
+// corner.
+func WeightedVoronoiStipple(pic image.Image, count, iterations int,
+	rngSeed int64) ([]image.Point, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("The number of points must be positive, "+
+			"got %d", count)
+	}
+	density, e := InvertedBrightness(pic)
+	if e != nil {
+		return nil, fmt.Errorf("Error computing density: %w", e)
+	}
+	w := density.W
+	h := density.H
+	if count > w*h {
+		return nil, fmt.Errorf("Can't place %d points in a %dx%d image",
+			count, w, h)
+	}
+	total := float64(0)
+	for _, v := range density.Pixels {
+		total += float64(v)
+	}
+	if !(total > 0) {
+		return nil, fmt.Errorf("The image doesn't contain any dark pixels")
+	}
+
+	// Use rejection sampling to choose the initial points, giving up after an
+	// arbitrary number of attempts so that nearly-white images still finish.
+	rng := rand.New(rand.NewSource(rngSeed))
+	occupied := make(map[image.Point]bool)
+	seeds := make([]image.Point, 0, count)
+	maxAttempts := 1000 * count
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if len(seeds) >= count {
+			break
+		}
+		p := image.Pt(rng.Intn(w), rng.Intn(h))
+		if occupied[p] {
+			continue
+		}
+		if rng.Float32() >= density.Pixels[p.Y*w+p.X] {
+			continue
+		}
+		occupied[p] = true
+		seeds = append(seeds, p)
+	}
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("Failed to place any initial points")
+	}
+	return LloydRelaxation(w, h, seeds, iterations, density)
+}
@@ -0,0 +1,302 @@
+package image_utils
+
+// This file contains functions for generating sets of seeds for Voronoi
+// diagrams.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// A seed for a Voronoi diagram, consisting of a location and the color of its
+// cell.
+type VoronoiSeed struct {
+	Location image.Point
+	Color    color.Color
+}
+
+// Adds each of the given seeds to the image. Returns an error if any seed is
+// outside of the image.
+func (v *VoronoiImage) AddSeeds(seeds []VoronoiSeed) error {
+	for _, s := range seeds {
+		e := v.AddSeed(s.Location, s.Color)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Returns a new VoronoiImage with the given dimensions containing the given
+// seeds. The returned image is ready for JumpFloodFill.
+func NewVoronoiImageFromSeeds(w, h int,
+	seeds []VoronoiSeed) (*VoronoiImage, error) {
+	toReturn, e := NewVoronoiImage(w, h)
+	if e != nil {
+		return nil, e
+	}
+	e = toReturn.AddSeeds(seeds)
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Returns a random, fully opaque, color.
+func randomColor(rng *rand.Rand) color.Color {
+	return color.RGBA{
+		R: uint8(rng.Intn(256)),
+		G: uint8(rng.Intn(256)),
+		B: uint8(rng.Intn(256)),
+		A: 0xff,
+	}
+}
+
+// Sets the color of each seed to the color of pic at the seed's location,
+// relative to the top-left corner of pic's bounds. Useful for producing
+// mosaics of an existing image.
+func ColorSeedsFromImage(seeds []VoronoiSeed, pic image.Image) {
+	min := pic.Bounds().Canon().Min
+	for i := range seeds {
+		p := seeds[i].Location.Add(min)
+		seeds[i].Color = pic.At(p.X, p.Y)
+	}
+}
+
+// Returns the given number of seeds, placed at distinct, uniformly random
+// pixels in a w x h image and assigned random colors. The rngSeed determines
+// the random number generator's output, so the same arguments always produce
+// the same seeds.
+func RandomSeeds(w, h, count int, rngSeed int64) ([]VoronoiSeed, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	if (count < 0) || (count > w*h) {
+		return nil, fmt.Errorf("Can't place %d seeds in a %dx%d image", count,
+			w, h)
+	}
+	rng := rand.New(rand.NewSource(rngSeed))
+	toReturn := make([]VoronoiSeed, 0, count)
+	if count > (w*h)/2 {
+		// Use a permutation for dense seeds, rather than waiting on many
+		// collisions.
+		for _, i := range rng.Perm(w * h)[:count] {
+			toReturn = append(toReturn, VoronoiSeed{
+				Location: image.Pt(i%w, i/w),
+				Color:    randomColor(rng),
+			})
+		}
+		return toReturn, nil
+	}
+	occupied := make(map[image.Point]bool)
+	for len(toReturn) < count {
+		p := image.Pt(rng.Intn(w), rng.Intn(h))
+		if occupied[p] {
+			continue
+		}
+		occupied[p] = true
+		toReturn = append(toReturn, VoronoiSeed{
+			Location: p,
+			Color:    randomColor(rng),
+		})
+	}
+	return toReturn, nil
+}
+
+// Divides a w x h image into a grid of square cells with the given size, and
+// returns one seed per cell with a random color. Each seed is offset from its
+// cell's center by a random amount, up to the jitter fraction of half the
+// cell size in each axis. A jitter of 0 produces a regular grid, and a jitter
+// of 1 allows seeds anywhere in their cells.
+func JitteredGridSeeds(w, h, cellSize int, jitter float64,
+	rngSeed int64) ([]VoronoiSeed, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	if cellSize <= 0 {
+		return nil, fmt.Errorf("The cell size must be positive, got %d",
+			cellSize)
+	}
+	if (jitter < 0) || (jitter > 1) {
+		return nil, fmt.Errorf("The jitter must be between 0 and 1, got %f",
+			jitter)
+	}
+	rng := rand.New(rand.NewSource(rngSeed))
+	toReturn := make([]VoronoiSeed, 0, (w/cellSize+1)*(h/cellSize+1))
+	for cellY := 0; cellY < h; cellY += cellSize {
+		for cellX := 0; cellX < w; cellX += cellSize {
+			cell := image.Rect(cellX, cellY, cellX+cellSize, cellY+cellSize)
+			cell = cell.Intersect(image.Rect(0, 0, w, h))
+			halfW := float64(cell.Dx()) / 2.0
+			halfH := float64(cell.Dy()) / 2.0
+			p := FloatPoint{
+				X: float64(cell.Min.X) + halfW - 0.5 +
+					(rng.Float64()*2.0-1.0)*jitter*halfW,
+				Y: float64(cell.Min.Y) + halfH - 0.5 +
+					(rng.Float64()*2.0-1.0)*jitter*halfH,
+			}.Round()
+			// Rounding may have pushed the point outside of its cell.
+			if p.X < cell.Min.X {
+				p.X = cell.Min.X
+			}
+			if p.X >= cell.Max.X {
+				p.X = cell.Max.X - 1
+			}
+			if p.Y < cell.Min.Y {
+				p.Y = cell.Min.Y
+			}
+			if p.Y >= cell.Max.Y {
+				p.Y = cell.Max.Y - 1
+			}
+			toReturn = append(toReturn, VoronoiSeed{
+				Location: p,
+				Color:    randomColor(rng),
+			})
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns seeds with random colors, placed in a w x h image using Bridson's
+// Poisson-disk sampling algorithm, so that no two seeds are closer than
+// minDistance pixels. The minDistance must be at least 1.
+func PoissonDiskSeeds(w, h int, minDistance float64,
+	rngSeed int64) ([]VoronoiSeed, error) {
+	if (w <= 0) || (h <= 0) {
+		return nil, fmt.Errorf("Invalid image dimensions: %dx%d", w, h)
+	}
+	if !(minDistance >= 1) {
+		return nil, fmt.Errorf("The minimum distance must be at least 1, "+
+			"got %f", minDistance)
+	}
+	// The number of candidates tried around each active point before it's
+	// retired, as suggested by Bridson.
+	attempts := 30
+	rng := rand.New(rand.NewSource(rngSeed))
+
+	// Each background grid cell is small enough to hold at most one point.
+	cellSize := minDistance / math.Sqrt2
+	gridW := int(math.Ceil(float64(w)/cellSize)) + 1
+	gridH := int(math.Ceil(float64(h)/cellSize)) + 1
+	grid := make([]int, gridW*gridH)
+	for i := range grid {
+		grid[i] = -1
+	}
+	points := make([]FloatPoint, 0, 256)
+	occupied := make(map[image.Point]bool)
+	toReturn := make([]VoronoiSeed, 0, 256)
+	addPoint := func(p FloatPoint) bool {
+		pixel := image.Pt(int(p.X), int(p.Y))
+		if occupied[pixel] {
+			return false
+		}
+		occupied[pixel] = true
+		gx := int(p.X / cellSize)
+		gy := int(p.Y / cellSize)
+		grid[gy*gridW+gx] = len(points)
+		points = append(points, p)
+		toReturn = append(toReturn, VoronoiSeed{
+			Location: pixel,
+			Color:    randomColor(rng),
+		})
+		return true
+	}
+	// Returns true if p is in bounds and far enough from all other points.
+	isValid := func(p FloatPoint) bool {
+		if (p.X < 0) || (p.Y < 0) || (p.X >= float64(w)) ||
+			(p.Y >= float64(h)) {
+			return false
+		}
+		gx := int(p.X / cellSize)
+		gy := int(p.Y / cellSize)
+		for j := gy - 2; j <= gy+2; j++ {
+			if (j < 0) || (j >= gridH) {
+				continue
+			}
+			for i := gx - 2; i <= gx+2; i++ {
+				if (i < 0) || (i >= gridW) {
+					continue
+				}
+				other := grid[j*gridW+i]
+				if other < 0 {
+					continue
+				}
+				dx := points[other].X - p.X
+				dy := points[other].Y - p.Y
+				if (dx*dx + dy*dy) < (minDistance * minDistance) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	addPoint(FloatPoint{
+		X: float64(rng.Intn(w)),
+		Y: float64(rng.Intn(h)),
+	})
+	active := []int{0}
+	for len(active) > 0 {
+		activeIndex := rng.Intn(len(active))
+		center := points[active[activeIndex]]
+		found := false
+		for i := 0; i < attempts; i++ {
+			// Pick a random point in the annulus between minDistance and
+			// twice minDistance from the center.
+			angle := rng.Float64() * 2.0 * math.Pi
+			distance := minDistance * (1.0 + rng.Float64())
+			// Snap candidates to pixel coordinates, so that the distance
+			// between the final seed locations is checked.
+			candidate := FloatPoint{
+				X: math.Floor(center.X + distance*math.Cos(angle)),
+				Y: math.Floor(center.Y + distance*math.Sin(angle)),
+			}
+			if !isValid(candidate) || !addPoint(candidate) {
+				continue
+			}
+			active = append(active, len(points)-1)
+			found = true
+			break
+		}
+		if !found {
+			active[activeIndex] = active[len(active)-1]
+			active = active[:len(active)-1]
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns a seed for each pixel in mask that is at least half-way bright and
+// not fully transparent. Each seed's color is taken from the same location in
+// the colors image, or from the mask itself if colors is nil. Seed locations
+// are relative to the top-left corner of the mask's bounds, and the colors
+// image is sampled relative to the top-left corner of its own bounds.
+func MaskSeeds(mask, colors image.Image) ([]VoronoiSeed, error) {
+	bounds := mask.Bounds().Canon()
+	if colors == nil {
+		colors = mask
+	}
+	colorsMin := colors.Bounds().Canon().Min
+	toReturn := make([]VoronoiSeed, 0, 256)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := mask.At(x, y)
+			_, _, _, a := c.RGBA()
+			if (a == 0) || (convertToBrightness(c) < 0.5) {
+				continue
+			}
+			p := image.Pt(x-bounds.Min.X, y-bounds.Min.Y)
+			toReturn = append(toReturn, VoronoiSeed{
+				Location: p,
+				Color:    colors.At(colorsMin.X+p.X, colorsMin.Y+p.Y),
+			})
+		}
+	}
+	if len(toReturn) == 0 {
+		return nil, fmt.Errorf("The mask doesn't contain any set pixels")
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,226 @@
+package image_utils
+
+// This file contains a generator for Worley (cellular) noise.
+
+import (
+	"fmt"
+	"math"
+)
+
+// Mixes the bits of v, using the finalizer from the SplitMix64 generator.
+func mixBits(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
+// Returns a pseudorandom hash of the seed and lattice coordinates.
+func latticeHash(seed int64, x, y int) uint64 {
+	h := mixBits(uint64(seed) + 0x9e3779b97f4a7c15)
+	h = mixBits(h ^ uint64(int64(x)))
+	return mixBits(h ^ (uint64(int64(y)) * 0x9e3779b97f4a7c15))
+}
+
+// Converts the top 53 bits of a hash to a float64 in [0, 1).
+func hashToFloat(h uint64) float64 {
+	return float64(h>>11) / float64(uint64(1)<<53)
+}
+
+// Selects how the distances to the closest feature points are combined into
+// a Worley noise value.
+type WorleyMode int
+
+const (
+	// The distance to the closest feature point.
+	WorleyF1 WorleyMode = iota
+	// The distance to the second-closest feature point.
+	WorleyF2
+	// The difference between the second-closest and closest distances,
+	// which highlights the borders between cells.
+	WorleyF2MinusF1
+	// The sum of the closest and second-closest distances.
+	WorleyF1PlusF2
+)
+
+func (m WorleyMode) String() string {
+	switch m {
+	case WorleyF1:
+		return "F1"
+	case WorleyF2:
+		return "F2"
+	case WorleyF2MinusF1:
+		return "F2-F1"
+	case WorleyF1PlusF2:
+		return "F1+F2"
+	}
+	return fmt.Sprintf("unknown Worley mode %d", int(m))
+}
+
+// Holds the parameters for generating Worley noise.
+type WorleyOptions struct {
+	// The width and height of the generated image.
+	W, H int
+	// The approximate width and height, in pixels, of the grid cells used in
+	// the first octave. Each cell contains one feature point.
+	CellSize float64
+	// How the distances to feature points are combined.
+	Mode WorleyMode
+	// The metric used to compute distances. Uses EuclideanDistance if nil.
+	Metric DistanceMetric
+	// The number of octaves of noise to sum. Defaults to 1 if 0.
+	Octaves int
+	// The factor by which the number of cells increases in each octave.
+	// Defaults to 2 if 0.
+	Lacunarity float64
+	// The factor by which each octave's amplitude is scaled relative to the
+	// previous octave. Defaults to 0.5 if 0.
+	Gain float64
+	// If true, the noise wraps around at the image's edges, so the image can
+	// be tiled seamlessly. The number of cells in each octave is rounded so
+	// that a whole number of cells spans the image.
+	Tileable bool
+	// Determines the locations of the feature points. The same options always
+	// produce the same image.
+	Seed int64
+}
+
+// Returns the values of F1 and F2 at (u, v), in units of cells, for a single
+// octave. If cellsX and cellsY are positive, the grid wraps after that many
+// cells.
+func worleyDistances(u, v float64, cellsX, cellsY int, seed int64,
+	metric DistanceMetric) (float64, float64) {
+	baseX := int(math.Floor(u))
+	baseY := int(math.Floor(v))
+	f1 := math.Inf(1)
+	f2 := math.Inf(1)
+	// Checking two cells in each direction ensures that F2 is correct even
+	// when a neighboring cell's feature point is near its far side.
+	for j := baseY - 2; j <= baseY+2; j++ {
+		for i := baseX - 2; i <= baseX+2; i++ {
+			hashX := i
+			hashY := j
+			if cellsX > 0 {
+				hashX = ((i % cellsX) + cellsX) % cellsX
+				hashY = ((j % cellsY) + cellsY) % cellsY
+			}
+			h := latticeHash(seed, hashX, hashY)
+			pointX := float64(i) + hashToFloat(h)
+			pointY := float64(j) + hashToFloat(mixBits(h))
+			d := float64(metric(float32(pointX-u), float32(pointY-v)))
+			if d < f1 {
+				f2 = f1
+				f1 = d
+			} else if d < f2 {
+				f2 = d
+			}
+		}
+	}
+	return f1, f2
+}
+
+// Generates an image containing Worley noise, also known as cellular noise.
+// The values in the returned image's Pixels are rescaled to span the range
+// [0, 1].
+func WorleyNoise(opts *WorleyOptions) (*FloatGrayscaleImage, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("Worley noise options are required")
+	}
+	if !(opts.CellSize > 0) {
+		return nil, fmt.Errorf("The cell size must be positive, got %f",
+			opts.CellSize)
+	}
+	if (opts.Mode < WorleyF1) || (opts.Mode > WorleyF1PlusF2) {
+		return nil, fmt.Errorf("Invalid Worley mode: %s", opts.Mode)
+	}
+	octaves := opts.Octaves
+	if octaves == 0 {
+		octaves = 1
+	}
+	if octaves < 0 {
+		return nil, fmt.Errorf("The number of octaves can't be negative")
+	}
+	lacunarity := opts.Lacunarity
+	if lacunarity == 0 {
+		lacunarity = 2.0
+	}
+	gain := opts.Gain
+	if gain == 0 {
+		gain = 0.5
+	}
+	metric := opts.Metric
+	if metric == nil {
+		metric = EuclideanDistance
+	}
+	toReturn, e := NewFloatGrayscaleImage(opts.W, opts.H)
+	if e != nil {
+		return nil, e
+	}
+	w := opts.W
+	h := opts.H
+	values := make([]float64, w*h)
+	cellSize := opts.CellSize
+	amplitude := 1.0
+	for octave := 0; octave < octaves; octave++ {
+		cellW := cellSize
+		cellH := cellSize
+		cellsX := 0
+		cellsY := 0
+		if opts.Tileable {
+			cellsX = int(math.Round(float64(w) / cellSize))
+			if cellsX < 1 {
+				cellsX = 1
+			}
+			cellsY = int(math.Round(float64(h) / cellSize))
+			if cellsY < 1 {
+				cellsY = 1
+			}
+			cellW = float64(w) / float64(cellsX)
+			cellH = float64(h) / float64(cellsY)
+		}
+		seed := opts.Seed + int64(octave)*0x632be59bd9b4e019
+		for y := 0; y < h; y++ {
+			v := (float64(y) + 0.5) / cellH
+			for x := 0; x < w; x++ {
+				u := (float64(x) + 0.5) / cellW
+				f1, f2 := worleyDistances(u, v, cellsX, cellsY, seed, metric)
+				var value float64
+				switch opts.Mode {
+				case WorleyF1:
+					value = f1
+				case WorleyF2:
+					value = f2
+				case WorleyF2MinusF1:
+					value = f2 - f1
+				case WorleyF1PlusF2:
+					value = f1 + f2
+				}
+				values[y*w+x] += value * amplitude
+			}
+		}
+		cellSize /= lacunarity
+		amplitude *= gain
+	}
+
+	// Rescale the values to [0, 1].
+	minValue := math.Inf(1)
+	maxValue := math.Inf(-1)
+	for _, v := range values {
+		if v < minValue {
+			minValue = v
+		}
+		if v > maxValue {
+			maxValue = v
+		}
+	}
+	scale := 0.0
+	if maxValue > minValue {
+		scale = 1.0 / (maxValue - minValue)
+	}
+	for i, v := range values {
+		toReturn.Pixels[i] = float32((v - minValue) * scale)
+	}
+	return toReturn, nil
+}